@@ -0,0 +1,88 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/intelsdi-x/snap-plugin-publisher-influxdb/influxdb"
+)
+
+// check diagnoses the connection to InfluxDB of a configuration and prints a report
+func check(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "configuration of the publisher, flat YAML or JSON")
+	asJSON := flags.Bool("json", false, "print the report in JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: snap-plugin-publisher-influxdb check -config <file> [-json]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *configPath == "" {
+		flags.Usage()
+		return 2
+	}
+
+	config, err := readConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "check:", err)
+		return 1
+	}
+
+	report := influxdb.Check(config)
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintln(stderr, "check:", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, string(data))
+	} else {
+		printReport(stdout, report)
+	}
+
+	if report.Failed() {
+		return 1
+	}
+	return 0
+}
+
+// printReport prints one line per check, aligned in columns
+func printReport(w io.Writer, report influxdb.CheckReport) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "endpoint\t%s\n", report.Endpoint)
+	fmt.Fprintf(tw, "database\t%s\n", report.Database)
+	fmt.Fprintf(tw, "retention\t%s\n", report.Retention)
+	tw.Flush()
+	fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, r := range report.Results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Name, r.Status, r.Detail)
+	}
+	tw.Flush()
+}
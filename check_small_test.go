@@ -0,0 +1,62 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-publisher-influxdb/influxdb"
+)
+
+func TestCheck(t *testing.T) {
+	Convey("Given an invalid configuration", t, func() {
+		dir, err := ioutil.TempDir("", "influxdb")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "config.yml")
+		So(ioutil.WriteFile(path, []byte("host: localhost\ndatabase: test\nscheme: htp\n"), 0644), ShouldBeNil)
+
+		Convey("the report shows the failed check", func() {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			So(check([]string{"-config", path}, strings.NewReader(""), stdout, stderr), ShouldEqual, 1)
+			So(stdout.String(), ShouldContainSubstring, "config     failed")
+			So(stdout.String(), ShouldContainSubstring, "ping       skipped")
+		})
+
+		Convey("the report can be printed in JSON", func() {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			So(check([]string{"-config", path, "-json"}, strings.NewReader(""), stdout, stderr), ShouldEqual, 1)
+			report := influxdb.CheckReport{}
+			So(json.Unmarshal(stdout.Bytes(), &report), ShouldBeNil)
+			So(report.Results[0].Name, ShouldEqual, "config")
+			So(report.Results[0].Status, ShouldEqual, influxdb.CheckFailed)
+		})
+	})
+}
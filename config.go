@@ -0,0 +1,84 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+	"github.com/intelsdi-x/snap-plugin-publisher-influxdb/influxdb"
+)
+
+// readConfig reads the configuration of the publisher from a file holding either
+// a JSON object or flat YAML, one "key: value" per line, as in the config of a task
+func readConfig(path string) (plugin.Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	} else {
+		values, err = parseFlatYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	return influxdb.NewConfig(values)
+}
+
+// parseFlatYAML parses "key: value" lines, values are strings converted later on to the type of their item
+func parseFlatYAML(data []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line == "---" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("line %d: expected 'key: value'", i+1)
+		}
+
+		value := strings.TrimSpace(kv[1])
+		switch {
+		case len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0]:
+			value = value[1 : len(value)-1]
+		case value == "":
+			return nil, fmt.Errorf("line %d: missing value of %s, nested values are not supported", i+1, key)
+		default:
+			// Strip comments following unquoted values
+			if c := strings.Index(value, " #"); c >= 0 {
+				value = strings.TrimSpace(value[:c])
+			}
+		}
+		values[key] = value
+	}
+	return values, nil
+}
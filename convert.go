@@ -0,0 +1,168 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+	"github.com/intelsdi-x/snap-plugin-publisher-influxdb/influxdb"
+)
+
+// jsonMetric is a metric serialized in JSON, its namespace is either
+// a list of namespace elements or a string such as "/intel/psutil/load/load1"
+type jsonMetric struct {
+	Namespace   json.RawMessage
+	Data        interface{}
+	Timestamp   time.Time
+	Tags        map[string]string
+	Unit        string
+	Description string
+}
+
+// convert prints the line protocol of metrics read in JSON, as they would be published
+func convert(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "configuration of the publisher, flat YAML or JSON")
+	metricsPath := flags.String("metrics", "-", "metrics in JSON, a list or a stream of metrics, - for the standard input")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: snap-plugin-publisher-influxdb convert -config <file> [-metrics <file>]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *configPath == "" {
+		flags.Usage()
+		return 2
+	}
+
+	config, err := readConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "convert:", err)
+		return 1
+	}
+
+	in := stdin
+	if *metricsPath != "-" {
+		f, err := os.Open(*metricsPath)
+		if err != nil {
+			fmt.Fprintln(stderr, "convert:", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	metrics, err := readMetrics(in)
+	if err != nil {
+		fmt.Fprintln(stderr, "convert:", err)
+		return 1
+	}
+
+	if err := influxdb.Convert(stdout, metrics, config); err != nil {
+		fmt.Fprintln(stderr, "convert:", err)
+		return 1
+	}
+	return 0
+}
+
+// readMetrics reads metrics in JSON, either lists of metrics or metrics one after the other.
+// Metrics without timestamp are timestamped with the current time.
+func readMetrics(r io.Reader) ([]plugin.Metric, error) {
+	metrics := []plugin.Metric{}
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return metrics, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		batch := []jsonMetric{}
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			if err := unmarshal(raw, &batch); err != nil {
+				return nil, err
+			}
+		} else {
+			var m jsonMetric
+			if err := unmarshal(raw, &m); err != nil {
+				return nil, err
+			}
+			batch = append(batch, m)
+		}
+
+		for _, m := range batch {
+			metric, err := m.metric()
+			if err != nil {
+				return nil, err
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+}
+
+// unmarshal decodes JSON keeping numbers as json.Number, so that integers are published as integers
+func unmarshal(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func (m jsonMetric) metric() (plugin.Metric, error) {
+	metric := plugin.Metric{
+		Data:        m.Data,
+		Timestamp:   m.Timestamp,
+		Tags:        m.Tags,
+		Unit:        m.Unit,
+		Description: m.Description,
+	}
+	if metric.Timestamp.IsZero() {
+		metric.Timestamp = time.Now()
+	}
+	if n, ok := m.Data.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			metric.Data = i
+		} else if f, err := n.Float64(); err == nil {
+			metric.Data = f
+		} else {
+			return metric, fmt.Errorf("invalid data %s: %s", n, err)
+		}
+	}
+
+	if len(m.Namespace) == 0 {
+		return metric, fmt.Errorf("metric without namespace")
+	}
+	var ns string
+	if err := json.Unmarshal(m.Namespace, &ns); err == nil {
+		metric.Namespace = plugin.NewNamespace(strings.Split(strings.Trim(ns, "/"), "/")...)
+	} else if err := json.Unmarshal(m.Namespace, &metric.Namespace); err != nil {
+		return metric, fmt.Errorf("invalid namespace %s: %s", m.Namespace, err)
+	}
+	return metric, nil
+}
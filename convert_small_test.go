@@ -0,0 +1,94 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConvert(t *testing.T) {
+	Convey("Given a configuration file", t, func() {
+		dir, err := ioutil.TempDir("", "influxdb")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "config.yml")
+		So(ioutil.WriteFile(path, []byte(`---
+# publisher
+host: "localhost"
+database: test
+precision: s
+isMultiFields: false # one field per point
+`), 0644), ShouldBeNil)
+
+		Convey("flat YAML is read with the types and defaults of the config policy", func() {
+			config, err := readConfig(path)
+			So(err, ShouldBeNil)
+			So(config["host"], ShouldEqual, "localhost")
+			So(config["isMultiFields"], ShouldEqual, false)
+			So(config["port"], ShouldEqual, int64(8086))
+			So(config["retention"], ShouldEqual, "autogen")
+		})
+
+		Convey("JSON is read as well", func() {
+			So(ioutil.WriteFile(path, []byte(`{"host": "localhost", "database": "test", "port": 8087}`), 0644), ShouldBeNil)
+			config, err := readConfig(path)
+			So(err, ShouldBeNil)
+			So(config["port"], ShouldEqual, int64(8087))
+		})
+
+		Convey("nested YAML is rejected", func() {
+			So(ioutil.WriteFile(path, []byte("config:\n  host: localhost\n"), 0644), ShouldBeNil)
+			_, err := readConfig(path)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("metrics are printed in the line protocol", func() {
+			stdin := strings.NewReader(`[
+				{"namespace": "/intel/psutil/load/load1", "data": 1.5, "timestamp": "2017-07-14T02:40:00Z", "tags": {"plugin_running_on": "host1"}},
+				{"namespace": [{"Value": "intel"}, {"Value": "psutil"}, {"Value": "load"}, {"Value": "load5"}], "data": 2, "timestamp": "2017-07-14T02:40:00Z"}
+			]`)
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			So(convert([]string{"-config", path}, stdin, stdout, stderr), ShouldEqual, 0)
+			So(stderr.String(), ShouldBeEmpty)
+			So(stdout.String(), ShouldEqual, "# batch db=test rp=autogen precision=s\n"+
+				"intel/psutil/load/load1,source=host1 value=1.5 1500000000\n"+
+				"intel/psutil/load/load5 value=2i 1500000000\n")
+		})
+
+		Convey("invalid metrics are reported", func() {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			So(convert([]string{"-config", path}, strings.NewReader(`{"data": 1}`), stdout, stderr), ShouldEqual, 1)
+			So(stderr.String(), ShouldContainSubstring, "metric without namespace")
+		})
+
+		Convey("the configuration is required", func() {
+			So(convert(nil, strings.NewReader(""), &bytes.Buffer{}, &bytes.Buffer{}), ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,268 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// Aggregators by destination and window
+	aggregators = make(map[string]*aggregator)
+	// Mutex for synchronizing aggregators changes
+	aggregatorsMutex = &sync.Mutex{}
+)
+
+// aggregator buffers numeric fields per series over a window and emits
+// their min, max, mean and count once the window is complete
+type aggregator struct {
+	window time.Duration
+	series map[string]*aggregate
+	// Configuration of the task which created the aggregator, used to write pending aggregates when the plugin stops
+	config configuration
+	logger *log.Entry
+	mutex  sync.Mutex
+}
+
+// aggregate holds the state of one series in the current window
+type aggregate struct {
+	ns      []string
+	tags    map[string]string
+	start   time.Time
+	numeric map[string]*fieldStats
+	last    map[string]interface{}
+	dest    destination
+}
+
+type fieldStats struct {
+	min, max, sum float64
+	count         int64
+}
+
+// aggregatorFor returns the aggregator shared by every task writing to the same destination with the same window
+func aggregatorFor(config configuration, logger *log.Entry) *aggregator {
+	key := fmt.Sprintf("%s/%s", destinationKey(config), config.aggregateWindow)
+
+	aggregatorsMutex.Lock()
+	defer aggregatorsMutex.Unlock()
+
+	if aggregators[key] == nil {
+		a := newAggregator(config.aggregateWindow)
+		a.config, a.logger = config, logger
+		aggregators[key] = a
+	}
+	return aggregators[key]
+}
+
+// flushAggregates writes the aggregates of all aggregators waiting for their window to complete,
+// so that they are not lost when the plugin stops. Failures are logged.
+func flushAggregates() {
+	aggregatorsMutex.Lock()
+	all := aggregators
+	aggregators = make(map[string]*aggregator)
+	aggregatorsMutex.Unlock()
+
+	for _, a := range all {
+		points := a.flush()
+		if len(points) == 0 {
+			continue
+		}
+		if a.config.maxSeries > 0 {
+			n := len(points)
+			points = guardFor(a.config).filter(points, time.Now(), a.logger)
+			stats.drop(dropCardinality, n-len(points))
+		}
+		batches, destinations, err := batchPoints(points, a.config, a.logger)
+		if err == nil {
+			err = writeBatches(batches, destinations, a.config, a.logger)
+		}
+		if err != nil {
+			a.logger.WithField("err", err).Warn("Unable to write pending aggregates")
+		}
+	}
+}
+
+// pendingAggregates returns the number of aggregates of all aggregators waiting for their window to complete
+func pendingAggregates() int {
+	aggregatorsMutex.Lock()
+	defer aggregatorsMutex.Unlock()
+
+	n := 0
+	for _, a := range aggregators {
+		n += a.pending()
+	}
+	return n
+}
+
+func newAggregator(window time.Duration) *aggregator {
+	return &aggregator{
+		window: window,
+		series: make(map[string]*aggregate),
+	}
+}
+
+// add buffers the numeric fields of points and returns the aggregates of every
+// window completed either by a newer sample or by the current time.
+// Points without numeric fields are returned unchanged.
+func (a *aggregator) add(points []point, now time.Time) []point {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	out := []point{}
+	for _, p := range points {
+		numeric := map[string]float64{}
+		for k, v := range p.fields {
+			if f, ok := toFloat(v); ok {
+				numeric[k] = f
+			}
+		}
+		if len(numeric) == 0 {
+			out = append(out, p)
+			continue
+		}
+
+		key := p.seriesKey()
+		start := p.ts.Truncate(a.window)
+		agg := a.series[key]
+		if agg != nil && !agg.start.Equal(start) {
+			// The sample belongs to another window, the current one is done
+			out = append(out, agg.point())
+			agg = nil
+		}
+		if agg == nil {
+			agg = &aggregate{
+				ns:      p.ns,
+				tags:    p.tags,
+				start:   start,
+				numeric: map[string]*fieldStats{},
+				last:    map[string]interface{}{},
+				dest:    p.dest,
+			}
+			a.series[key] = agg
+		}
+
+		for k, v := range p.fields {
+			f, ok := numeric[k]
+			if !ok {
+				agg.last[k] = v
+				continue
+			}
+			s := agg.numeric[k]
+			if s == nil {
+				agg.numeric[k] = &fieldStats{min: f, max: f, sum: f, count: 1}
+				continue
+			}
+			s.min = math.Min(s.min, f)
+			s.max = math.Max(s.max, f)
+			s.sum += f
+			s.count++
+		}
+	}
+
+	for key, agg := range a.series {
+		if !agg.start.Add(a.window).After(now) {
+			out = append(out, agg.point())
+			delete(a.series, key)
+		}
+	}
+	return out
+}
+
+// point converts an aggregate into a point. The "value" field of single field metrics
+// is emitted as min, max, mean and count, any other field f as f_min, f_max, f_mean and f_count.
+func (agg *aggregate) point() point {
+	fields := map[string]interface{}{}
+	for k, v := range agg.last {
+		fields[k] = v
+	}
+	for k, s := range agg.numeric {
+		prefix := k + "_"
+		if k == "value" {
+			prefix = ""
+		}
+		fields[prefix+"min"] = s.min
+		fields[prefix+"max"] = s.max
+		fields[prefix+"mean"] = s.sum / float64(s.count)
+		fields[prefix+"count"] = s.count
+	}
+	return point{ns: agg.ns, tags: agg.tags, ts: agg.start, fields: fields, dest: agg.dest}
+}
+
+// toFloat converts numeric values to float64, NaN and infinite values are not considered numeric
+func toFloat(v interface{}) (float64, bool) {
+	var f float64
+	switch n := v.(type) {
+	case int:
+		f = float64(n)
+	case int8:
+		f = float64(n)
+	case int16:
+		f = float64(n)
+	case int32:
+		f = float64(n)
+	case int64:
+		f = float64(n)
+	case uint:
+		f = float64(n)
+	case uint8:
+		f = float64(n)
+	case uint16:
+		f = float64(n)
+	case uint32:
+		f = float64(n)
+	case uint64:
+		f = float64(n)
+	case float32:
+		f = float64(n)
+	case float64:
+		f = n
+	default:
+		return 0, false
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// flush returns the aggregates of every series, whether their window is complete or not
+func (a *aggregator) flush() []point {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	out := make([]point, 0, len(a.series))
+	for key, agg := range a.series {
+		out = append(out, agg.point())
+		delete(a.series, key)
+	}
+	return out
+}
+
+// pending returns the number of series buffered in their current window
+func (a *aggregator) pending() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return len(a.series)
+}
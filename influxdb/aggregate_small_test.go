@@ -0,0 +1,135 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+func TestAggregator(t *testing.T) {
+	Convey("Given an aggregator with a one minute window", t, func() {
+		agg := newAggregator(time.Minute)
+		start := time.Date(2017, 7, 1, 10, 0, 0, 0, time.UTC)
+		sample := func(offset time.Duration, value interface{}) point {
+			return point{
+				ns:     []string{"intel", "psutil", "load", "load1"},
+				tags:   map[string]string{"source": "host1"},
+				ts:     start.Add(offset),
+				fields: map[string]interface{}{"value": value, "unit": "Load/1M"},
+			}
+		}
+
+		Convey("samples are buffered until the window is complete", func() {
+			out := agg.add([]point{sample(0, 1.0), sample(10*time.Second, int64(3))}, start.Add(20*time.Second))
+			So(out, ShouldBeEmpty)
+			So(agg.pending(), ShouldEqual, 1)
+
+			Convey("and emitted as min, max, mean and count once a newer window starts", func() {
+				out := agg.add([]point{sample(70*time.Second, 5.0)}, start.Add(70*time.Second))
+				So(out, ShouldHaveLength, 1)
+				So(out[0].ts, ShouldResemble, start)
+				So(out[0].fields, ShouldResemble, map[string]interface{}{
+					"min":   1.0,
+					"max":   3.0,
+					"mean":  2.0,
+					"count": int64(2),
+					"unit":  "Load/1M",
+				})
+			})
+
+			Convey("and emitted once the current time passes the end of the window", func() {
+				out := agg.add([]point{}, start.Add(2*time.Minute))
+				So(out, ShouldHaveLength, 1)
+				So(out[0].fields["count"], ShouldEqual, int64(2))
+			})
+		})
+
+		Convey("fields other than value are prefixed with their name", func() {
+			p := sample(0, 1.0)
+			p.fields = map[string]interface{}{"load1": 4.0}
+			out := agg.add([]point{p}, start.Add(time.Minute))
+			So(out, ShouldHaveLength, 1)
+			So(out[0].fields, ShouldContainKey, "load1_mean")
+		})
+
+		Convey("points without numeric fields are passed through", func() {
+			out := agg.add([]point{sample(0, "running")}, start)
+			So(out, ShouldHaveLength, 1)
+			So(out[0].fields["value"], ShouldEqual, "running")
+		})
+	})
+}
+
+func TestFlushAggregates(t *testing.T) {
+	Convey("Given a task aggregating metrics over an hour", t, func() {
+		var mutex sync.Mutex
+		writes := []string{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			mutex.Lock()
+			writes = append(writes, string(body))
+			mutex.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+		u, _ := url.Parse(ts.URL)
+		host, p, _ := net.SplitHostPort(u.Host)
+		port, _ := strconv.ParseInt(p, 10, 64)
+
+		config := plugin.Config{
+			"host":             host,
+			"port":             port,
+			"database":         "test",
+			"retention":        "autogen",
+			"scheme":           HTTP,
+			"skip-verify":      false,
+			"isMultiFields":    false,
+			"create-database":  CreateDatabaseNever,
+			"aggregate-window": "1h",
+		}
+		ip := NewInfluxPublisher()
+		err := ip.Publish([]plugin.Metric{
+			{Namespace: plugin.NewNamespace("intel", "cpu", "idle"), Timestamp: time.Now(), Data: 1.5},
+		}, config)
+		So(err, ShouldBeNil)
+		So(writes, ShouldBeEmpty)
+
+		Convey("pending aggregates are written when the publisher is closed", func() {
+			So(ip.Close(), ShouldBeNil)
+			So(writes, ShouldHaveLength, 1)
+			So(writes[0], ShouldContainSubstring, "intel/cpu/idle ")
+			So(writes[0], ShouldContainSubstring, "mean=1.5")
+			So(pendingAggregates(), ShouldEqual, 0)
+		})
+	})
+}
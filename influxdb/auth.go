@@ -0,0 +1,133 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+var (
+	// References to environment variables, e.g. "${INFLUXDB_PASSWORD}"
+	envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	// Secrets read from files by path
+	secretFiles = make(map[string]secretFile)
+	// Mutex for synchronizing secret files changes
+	secretFilesMutex = &sync.Mutex{}
+)
+
+// credentials authenticate requests to InfluxDB
+type credentials struct {
+	user, password, token string
+}
+
+// secretFile is the content of a file holding a secret, along with the state of the file when it was read
+type secretFile struct {
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+// authorize adds the credentials to a request, a token takes precedence over user and password
+func (c credentials) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Token "+c.token)
+		return
+	}
+	if c.user != "" || c.password != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+}
+
+// fingerprint identifies the credentials without revealing them
+func (c credentials) fingerprint() string {
+	sum := sha256.Sum256([]byte(c.user + separator + c.password + separator + c.token))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// getSecret reads a secret either from key, where ${ENV} references are expanded,
+// or from the file named by key-file, which is read again whenever it changes.
+// A missing secret is empty.
+func getSecret(config plugin.Config, key string) (string, error) {
+	value, valueErr := config.GetString(key)
+	path, pathErr := config.GetString(key + "-file")
+	if pathErr != nil || path == "" {
+		if valueErr != nil {
+			return "", nil
+		}
+		return expandEnv(value, key)
+	}
+	if valueErr == nil && value != "" {
+		return "", fmt.Errorf("%s and %s-file are mutually exclusive", key, key)
+	}
+
+	path, err := expandEnv(path, key+"-file")
+	if err != nil {
+		return "", err
+	}
+	return readSecretFile(path)
+}
+
+// expandEnv replaces ${ENV} references with the value of the environment variables
+func expandEnv(value, key string) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s referenced by %s is not set", name, key)
+		}
+		return v
+	})
+	return expanded, err
+}
+
+// readSecretFile returns the content of a file without surrounding whitespaces,
+// the file is only read again when its modification time or size changed
+func readSecretFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	secretFilesMutex.Lock()
+	defer secretFilesMutex.Unlock()
+
+	if s, ok := secretFiles[path]; ok && s.modTime.Equal(info.ModTime()) && s.size == info.Size() {
+		return s.value, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(data))
+	secretFiles[path] = secretFile{modTime: info.ModTime(), size: info.Size(), value: value}
+	return value, nil
+}
@@ -0,0 +1,95 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+func TestSecrets(t *testing.T) {
+	Convey("Secrets can reference environment variables", t, func() {
+		os.Setenv("SNAP_INFLUXDB_TEST_PASSWORD", "s3cr3t")
+		defer os.Unsetenv("SNAP_INFLUXDB_TEST_PASSWORD")
+
+		secret, err := getSecret(plugin.Config{"password": "${SNAP_INFLUXDB_TEST_PASSWORD}"}, "password")
+		So(err, ShouldBeNil)
+		So(secret, ShouldEqual, "s3cr3t")
+
+		secret, err = getSecret(plugin.Config{"password": "pa$$word"}, "password")
+		So(err, ShouldBeNil)
+		So(secret, ShouldEqual, "pa$$word")
+
+		_, err = getSecret(plugin.Config{"password": "${SNAP_INFLUXDB_TEST_MISSING}"}, "password")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given a secret file", t, func() {
+		dir, _ := ioutil.TempDir("", "snap-influxdb")
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "token")
+		ioutil.WriteFile(file, []byte("first\n"), 0600)
+		config := plugin.Config{"token-file": file}
+
+		Convey("the secret is read from the file", func() {
+			secret, err := getSecret(config, "token")
+			So(err, ShouldBeNil)
+			So(secret, ShouldEqual, "first")
+
+			Convey("and read again once the file changed", func() {
+				ioutil.WriteFile(file, []byte("second\n"), 0600)
+				later := time.Now().Add(time.Minute)
+				os.Chtimes(file, later, later)
+				secret, err := getSecret(config, "token")
+				So(err, ShouldBeNil)
+				So(secret, ShouldEqual, "second")
+			})
+		})
+
+		Convey("the secret cannot also be set directly", func() {
+			config["token"] = "inline"
+			_, err := getSecret(config, "token")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Credentials authorize requests", t, func() {
+		req, _ := http.NewRequest("GET", "http://localhost:8086/query", nil)
+		credentials{user: "admin", password: "admin"}.authorize(req)
+		user, pass, ok := req.BasicAuth()
+		So(ok, ShouldBeTrue)
+		So(user+":"+pass, ShouldEqual, "admin:admin")
+
+		req, _ = http.NewRequest("GET", "http://localhost:8086/query", nil)
+		credentials{user: "admin", token: "abc"}.authorize(req)
+		So(req.Header.Get("Authorization"), ShouldEqual, "Token abc")
+
+		So(credentials{password: "a"}.fingerprint(), ShouldNotEqual, credentials{password: "b"}.fingerprint())
+	})
+}
@@ -0,0 +1,130 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// CardinalityDrop drops points of new series over the limit
+	CardinalityDrop = "drop"
+	// CardinalityStripTags strips the tags of points of new series over the limit
+	CardinalityStripTags = "strip-tags"
+)
+
+var (
+	// Cardinality guards by destination and settings
+	guards = make(map[string]*cardinalityGuard)
+	// Mutex for synchronizing guards changes
+	guardsMutex = &sync.Mutex{}
+)
+
+// cardinalityGuard tracks the series of every measurement and limits how many of them
+// can be written. Series which were not seen during the decay period are forgotten.
+type cardinalityGuard struct {
+	limit        int
+	action       string
+	decay        time.Duration
+	measurements map[string]map[string]time.Time
+	warned       map[string]time.Time
+	limited      uint64
+	mutex        sync.Mutex
+}
+
+// guardFor returns the cardinality guard shared by every task writing to the same destination with the same settings
+func guardFor(config configuration) *cardinalityGuard {
+	key := fmt.Sprintf("%s/%d/%s/%s", destinationKey(config), config.maxSeries, config.cardinalityAction, config.cardinalityDecay)
+
+	guardsMutex.Lock()
+	defer guardsMutex.Unlock()
+
+	if guards[key] == nil {
+		guards[key] = newCardinalityGuard(int(config.maxSeries), config.cardinalityAction, config.cardinalityDecay)
+	}
+	return guards[key]
+}
+
+func newCardinalityGuard(limit int, action string, decay time.Duration) *cardinalityGuard {
+	return &cardinalityGuard{
+		limit:        limit,
+		action:       action,
+		decay:        decay,
+		measurements: make(map[string]map[string]time.Time),
+		warned:       make(map[string]time.Time),
+	}
+}
+
+// filter returns the points which may be written. Points of new series exceeding the limit of their
+// measurement are either dropped or stripped of their tags, a warning is logged once per decay period.
+func (g *cardinalityGuard) filter(points []point, now time.Time, logger *log.Entry) []point {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	out := make([]point, 0, len(points))
+	for _, p := range points {
+		measurement := strings.Join(p.ns, "/")
+		series := g.measurements[measurement]
+		if series == nil {
+			series = make(map[string]time.Time)
+			g.measurements[measurement] = series
+		}
+
+		key := p.seriesKey()
+		if _, ok := series[key]; !ok && len(series) >= g.limit {
+			g.expire(series, now)
+		}
+		if _, ok := series[key]; ok || len(series) < g.limit {
+			series[key] = now
+			out = append(out, p)
+			continue
+		}
+
+		g.limited++
+		if now.Sub(g.warned[measurement]) > g.decay {
+			g.warned[measurement] = now
+			logger.WithFields(log.Fields{
+				"measurement":   measurement,
+				"limit":         g.limit,
+				"action":        g.action,
+				"total-limited": g.limited,
+			}).Warn("Series cardinality limit exceeded")
+		}
+		if g.action == CardinalityStripTags {
+			p.tags = map[string]string{}
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// expire forgets the series which were not seen during the decay period
+func (g *cardinalityGuard) expire(series map[string]time.Time, now time.Time) {
+	for k, seen := range series {
+		if now.Sub(seen) > g.decay {
+			delete(series, k)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCardinalityGuard(t *testing.T) {
+	logger := log.WithField("test", "cardinality")
+	now := time.Now()
+	request := func(id string) point {
+		return point{
+			ns:     []string{"app", "latency"},
+			tags:   map[string]string{"request": id},
+			ts:     now,
+			fields: map[string]interface{}{"value": 1},
+		}
+	}
+
+	Convey("Given a guard allowing two series per measurement", t, func() {
+		Convey("new series over the limit are dropped", func() {
+			g := newCardinalityGuard(2, CardinalityDrop, time.Hour)
+			out := g.filter([]point{request("a"), request("b"), request("c"), request("a")}, now, logger)
+			So(out, ShouldHaveLength, 3)
+			So(out[2].tags["request"], ShouldEqual, "a")
+			So(g.limited, ShouldEqual, uint64(1))
+		})
+
+		Convey("new series over the limit can be stripped of their tags", func() {
+			g := newCardinalityGuard(2, CardinalityStripTags, time.Hour)
+			out := g.filter([]point{request("a"), request("b"), request("c")}, now, logger)
+			So(out, ShouldHaveLength, 3)
+			So(out[2].tags, ShouldBeEmpty)
+		})
+
+		Convey("series which were not seen during the decay period are forgotten", func() {
+			g := newCardinalityGuard(2, CardinalityDrop, time.Hour)
+			g.filter([]point{request("a"), request("b")}, now, logger)
+			out := g.filter([]point{request("c")}, now.Add(2*time.Hour), logger)
+			So(out, ShouldHaveLength, 1)
+		})
+	})
+}
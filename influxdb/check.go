@@ -0,0 +1,262 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+const (
+	// CheckOK is the status of a successful check
+	CheckOK = "ok"
+	// CheckWarning is the status of a successful check revealing a possible problem
+	CheckWarning = "warning"
+	// CheckFailed is the status of a failed check
+	CheckFailed = "failed"
+	// CheckSkipped is the status of a check which could not be done
+	CheckSkipped = "skipped"
+
+	// Measurement receiving the point written by the write check
+	checkMeasurement = "snap_influxdb_publisher_check"
+	// How long to wait for the endpoint
+	checkTimeout = 10 * time.Second
+	// Certificates expiring sooner are reported as a warning
+	certificateExpiryWarning = 30 * 24 * time.Hour
+)
+
+// CheckResult is the outcome of one check
+type CheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// CheckReport lists the outcome of the checks of the connection to InfluxDB
+type CheckReport struct {
+	Endpoint  string        `json:"endpoint,omitempty"`
+	Database  string        `json:"database,omitempty"`
+	Retention string        `json:"retention,omitempty"`
+	Results   []CheckResult `json:"results"`
+}
+
+// Failed tells whether one of the checks failed
+func (r CheckReport) Failed() bool {
+	for _, result := range r.Results {
+		if result.Status == CheckFailed {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *CheckReport) add(name, status, format string, args ...interface{}) {
+	r.Results = append(r.Results, CheckResult{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// skip marks the remaining checks as skipped
+func (r *CheckReport) skip(reason string, names ...string) {
+	for _, name := range names {
+		r.add(name, CheckSkipped, "%s", reason)
+	}
+}
+
+// Check diagnoses the connection to InfluxDB of a configuration: its validity, the TLS settings,
+// the endpoint, authentication, the database, the retention policy and the permission to write,
+// by writing a point to the snap_influxdb_publisher_check measurement. Nothing is created.
+func Check(pluginConfig plugin.Config) CheckReport {
+	report := CheckReport{}
+
+	config, err := getConfig(pluginConfig)
+	if err != nil {
+		report.add("config", CheckFailed, "%s", err)
+		report.skip("invalid configuration", "tls", "ping", "auth", "database", "retention", "write")
+		return report
+	}
+	report.add("config", CheckOK, "")
+
+	u, err := endpointURL(config)
+	if err != nil {
+		report.add("tls", CheckFailed, "%s", err)
+		return report
+	}
+	report.Endpoint = u.String()
+	report.Database = config.database
+	report.Retention = config.retention
+
+	if config.scheme == HTTPS {
+		status, detail := checkTLS(u.Host, config.host, config.skipVerify)
+		report.add("tls", status, "%s", detail)
+	} else {
+		report.add("tls", CheckSkipped, "scheme is %s", config.scheme)
+	}
+
+	auth := config.credentials()
+	con, err := newClient(u, auth, config.skipVerify)
+	if err != nil {
+		report.add("ping", CheckFailed, "%s", err)
+		report.skip("no client", "auth", "database", "retention", "write")
+		return report
+	}
+	defer con.Close()
+
+	if config.scheme == UDP {
+		report.skip("not available over udp", "ping", "auth", "database", "retention")
+		report.addWrite(con, config, "the point was sent, its delivery cannot be verified over udp")
+		return report
+	}
+
+	rtt, version, err := con.Ping(checkTimeout)
+	if err != nil {
+		report.add("ping", CheckFailed, "%s", err)
+		report.skip("ping failed", "auth", "database", "retention", "write")
+		return report
+	}
+	report.add("ping", CheckOK, "InfluxDB %s answered in %s", version, rtt)
+
+	c := &clientConnection{url: u, auth: auth, skipVerify: config.skipVerify}
+	if _, err := c.query("GET", "SHOW DATABASES"); err != nil {
+		report.add("auth", CheckFailed, "%s", err)
+		report.skip("authentication failed", "database", "retention", "write")
+		return report
+	}
+	switch config.auth {
+	case AuthBasic:
+		report.add("auth", CheckOK, "authenticated as %s", auth.user)
+	case AuthToken:
+		report.add("auth", CheckOK, "authenticated with a token")
+	default:
+		report.add("auth", CheckOK, "no credentials sent")
+	}
+
+	if isTemplate(config.database) {
+		report.skip("database is a template", "database", "retention", "write")
+		return report
+	}
+	exists, err := c.dbExists(config.database)
+	switch {
+	case err != nil:
+		report.add("database", CheckFailed, "%s", err)
+		report.skip("database check failed", "retention", "write")
+		return report
+	case !exists && config.createDatabase == CreateDatabase:
+		report.add("database", CheckWarning, "database %s does not exist, it will be created when publishing", config.database)
+		report.skip("database does not exist", "retention", "write")
+		return report
+	case !exists:
+		report.add("database", CheckFailed, "database %s does not exist", config.database)
+		report.skip("database does not exist", "retention", "write")
+		return report
+	}
+	report.add("database", CheckOK, "database %s exists", config.database)
+
+	if isTemplate(config.retention) {
+		report.skip("retention policy is a template", "retention", "write")
+		return report
+	}
+	rps, err := c.retentionPolicies(config.database)
+	if err != nil {
+		report.add("retention", CheckFailed, "%s", err)
+		report.skip("retention policy check failed", "write")
+		return report
+	}
+	rp, ok := rps[config.retention]
+	switch {
+	case !ok && config.retentionPolicy.duration != "":
+		report.add("retention", CheckWarning, "retention policy %s does not exist, it will be created when publishing", config.retention)
+		report.skip("retention policy does not exist", "write")
+		return report
+	case !ok:
+		report.add("retention", CheckFailed, "retention policy %s does not exist", config.retention)
+		report.skip("retention policy does not exist", "write")
+		return report
+	case config.retentionPolicy.duration != "" && len(config.retentionPolicy.drift(rp)) > 0:
+		drift := strings.Join(config.retentionPolicy.drift(rp), ", ")
+		report.add("retention", CheckWarning, "retention policy %s differs from its configuration: %s", config.retention, drift)
+	default:
+		report.add("retention", CheckOK, "retention policy %s keeps data for %s", config.retention, infinite(rp.duration))
+	}
+
+	report.addWrite(con, config, "a point was written to the measurement %s", checkMeasurement)
+	return report
+}
+
+// addWrite writes a point to the check measurement and reports the outcome
+func (r *CheckReport) addWrite(con client.Client, config configuration, format string, args ...interface{}) {
+	host, _ := os.Hostname()
+	pt, err := client.NewPoint(checkMeasurement, map[string]string{"source": host}, map[string]interface{}{"value": true}, time.Now())
+	if err != nil {
+		r.add("write", CheckFailed, "%s", err)
+		return
+	}
+	bps, err := client.NewBatchPoints(client.BatchPointsConfig{Database: config.database, RetentionPolicy: config.retention, Precision: config.precision})
+	if err != nil {
+		r.add("write", CheckFailed, "%s", err)
+		return
+	}
+	bps.AddPoint(pt)
+	if err := con.Write(bps); err != nil {
+		r.add("write", CheckFailed, "%s", err)
+		return
+	}
+	r.add("write", CheckOK, format, args...)
+}
+
+// checkTLS connects to addr and reports the certificate presented by the server
+func checkTLS(addr, serverName string, skipVerify bool) (string, string) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: checkTimeout}, "tcp", addr, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: skipVerify,
+	})
+	if err != nil {
+		return CheckFailed, err.Error()
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return CheckFailed, "no certificate presented by the server"
+	}
+	cert := certs[0]
+	detail := fmt.Sprintf("certificate of %s issued by %s expires on %s", cert.Subject.CommonName, cert.Issuer.CommonName, cert.NotAfter.Format("2006-01-02"))
+	switch {
+	case skipVerify:
+		return CheckWarning, detail + ", it is not verified since skip-verify is true"
+	case cert.NotAfter.Sub(time.Now()) < certificateExpiryWarning:
+		return CheckWarning, detail + ", it expires soon"
+	}
+	return CheckOK, detail
+}
+
+// infinite formats the duration of a retention policy, 0 meaning it keeps data forever
+func infinite(d time.Duration) string {
+	if d == 0 {
+		return "ever"
+	}
+	return d.String()
+}
@@ -0,0 +1,122 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+func TestCheck(t *testing.T) {
+	Convey("Given an InfluxDB server", t, func() {
+		writes := 0
+		authorized := true
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query().Get("q")
+			switch {
+			case r.URL.Path == "/ping":
+				w.Header().Set("X-Influxdb-Version", "1.3.0")
+				w.WriteHeader(http.StatusNoContent)
+			case !authorized:
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"authorization failed"}`))
+			case r.URL.Path == "/write":
+				writes++
+				w.WriteHeader(http.StatusNoContent)
+			case q == "SHOW DATABASES":
+				w.Write([]byte(`{"results":[{"statement_id":0,"series":[{"name":"databases","columns":["name"],"values":[["_internal"],["test"]]}]}]}`))
+			case q == `SHOW RETENTION POLICIES ON "test"`:
+				w.Write([]byte(`{"results":[{"statement_id":0,"series":[{"columns":["name","duration","shardGroupDuration","replicaN","default"],"values":[["autogen","0s","168h0m0s",1,true]]}]}]}`))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		defer ts.Close()
+		u, _ := url.Parse(ts.URL)
+		host, p, _ := net.SplitHostPort(u.Host)
+		port, _ := strconv.ParseInt(p, 10, 64)
+
+		config := plugin.Config{
+			"host":            host,
+			"port":            port,
+			"database":        "test",
+			"retention":       "autogen",
+			"scheme":          HTTP,
+			"skip-verify":     false,
+			"isMultiFields":   false,
+			"create-database": CreateDatabaseVerifyOnly,
+		}
+		statuses := func(report CheckReport) map[string]string {
+			s := map[string]string{}
+			for _, r := range report.Results {
+				s[r.Name] = r.Status
+			}
+			return s
+		}
+
+		Convey("every check passes", func() {
+			report := Check(config)
+			So(report.Failed(), ShouldBeFalse)
+			So(statuses(report), ShouldResemble, map[string]string{
+				"config": CheckOK, "tls": CheckSkipped, "ping": CheckOK, "auth": CheckOK,
+				"database": CheckOK, "retention": CheckOK, "write": CheckOK,
+			})
+			So(writes, ShouldEqual, 1)
+		})
+
+		Convey("a missing database fails and skips the next checks", func() {
+			config["database"] = "other"
+			report := Check(config)
+			So(report.Failed(), ShouldBeTrue)
+			So(statuses(report)["database"], ShouldEqual, CheckFailed)
+			So(statuses(report)["write"], ShouldEqual, CheckSkipped)
+			So(writes, ShouldEqual, 0)
+		})
+
+		Convey("a missing retention policy fails", func() {
+			config["retention"] = "week"
+			report := Check(config)
+			So(statuses(report)["retention"], ShouldEqual, CheckFailed)
+		})
+
+		Convey("failed authentication is reported", func() {
+			authorized = false
+			report := Check(config)
+			So(statuses(report)["ping"], ShouldEqual, CheckOK)
+			So(statuses(report)["auth"], ShouldEqual, CheckFailed)
+		})
+
+		Convey("an invalid configuration is reported", func() {
+			config["scheme"] = "htp"
+			report := Check(config)
+			So(statuses(report)["config"], ShouldEqual, CheckFailed)
+			So(statuses(report)["ping"], ShouldEqual, CheckSkipped)
+		})
+	})
+}
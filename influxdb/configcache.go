@@ -0,0 +1,167 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+var (
+	// How long a parsed configuration is kept once it stops being used
+	configCacheTTL = time.Hour
+	// Parsed configurations by hash of the plugin configuration
+	parsedConfigs = newConfigCache()
+)
+
+// configCache keeps the configurations already parsed, so that validation,
+// templates, routes and logger setup happen once per distinct plugin configuration
+type configCache struct {
+	entries   map[string]*parsedConfig
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+type parsedConfig struct {
+	config configuration
+	logger *log.Entry
+	// Credentials read from files are resolved again on every publish
+	secretFiles bool
+	used        time.Time
+}
+
+func newConfigCache() *configCache {
+	return &configCache{entries: map[string]*parsedConfig{}}
+}
+
+// get returns the configuration and logger for a plugin configuration, parsing it on first use.
+// Invalid configurations are not cached so that fixing e.g. a missing file or environment variable is picked up.
+func (c *configCache) get(pluginConfig plugin.Config) (configuration, *log.Entry, error) {
+	key := configHash(pluginConfig)
+	now := time.Now()
+
+	c.mutex.Lock()
+	if now.Sub(c.lastPrune) > configCacheTTL {
+		for k, e := range c.entries {
+			if now.Sub(e.used) > configCacheTTL {
+				delete(c.entries, k)
+			}
+		}
+		c.lastPrune = now
+	}
+	entry, ok := c.entries[key]
+	if ok {
+		entry.used = now
+	}
+	c.mutex.Unlock()
+
+	if ok {
+		if !entry.secretFiles {
+			return entry.config, entry.logger, nil
+		}
+		config, err := refreshCredentials(entry.config, pluginConfig)
+		return config, entry.logger, err
+	}
+
+	config, err := getConfig(pluginConfig)
+	if err != nil {
+		return config, nil, err
+	}
+	// Tasks without a name are told apart in logs by their configuration
+	if config.task == "" {
+		config.task = key[:8]
+	}
+	entry = &parsedConfig{
+		config:      config,
+		logger:      getLogger(config),
+		secretFiles: hasSecretFiles(pluginConfig),
+		used:        now,
+	}
+	// Publishing doesn't depend on the Prometheus listener, failing to start it is only logged
+	if config.prometheusListen != "" {
+		if err := servePrometheus(config.prometheusListen, entry.logger); err != nil {
+			entry.logger.Error(err)
+		}
+	}
+
+	if config.statsDatabase != "" && !config.dryRun {
+		startStatsWriter(config, pluginConfig, entry.logger)
+	}
+
+	c.mutex.Lock()
+	c.entries[key] = entry
+	c.mutex.Unlock()
+	return entry.config, entry.logger, nil
+}
+
+// refreshCredentials reads again the credentials of a configuration which come from files
+// clear forgets the parsed configurations, so the Prometheus listeners and stats writers
+// stopped by Close are started again by the next publish
+func (c *configCache) clear() {
+	c.mutex.Lock()
+	c.entries = map[string]*parsedConfig{}
+	c.mutex.Unlock()
+}
+
+func refreshCredentials(config configuration, pluginConfig plugin.Config) (configuration, error) {
+	var err error
+	config.password, err = getSecret(pluginConfig, "password")
+	if err != nil {
+		return config, err
+	}
+	config.token, err = getSecret(pluginConfig, "token")
+	if err != nil {
+		return config, err
+	}
+	config.auth, err = getAuth(pluginConfig, config)
+	return config, err
+}
+
+// hasSecretFiles tells whether credentials are read from files
+func hasSecretFiles(pluginConfig plugin.Config) bool {
+	for _, key := range []string{"password-file", "token-file"} {
+		if path, err := pluginConfig.GetString(key); err == nil && path != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// configHash identifies a plugin configuration by its keys and values
+func configHash(pluginConfig plugin.Config) string {
+	keys := make([]string, 0, len(pluginConfig))
+	for k := range pluginConfig {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%q=%T:%v\n", k, pluginConfig[k], pluginConfig[k])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)[:16])
+}
@@ -0,0 +1,105 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+func TestConfigCache(t *testing.T) {
+	Convey("Given a configuration", t, func() {
+		config := plugin.Config{
+			"host":          "localhost",
+			"port":          int64(8086),
+			"database":      "test",
+			"retention":     "autogen",
+			"scheme":        HTTP,
+			"skip-verify":   false,
+			"isMultiFields": false,
+		}
+		cache := newConfigCache()
+
+		Convey("it is parsed once", func() {
+			cfg, logger, err := cache.get(config)
+			So(err, ShouldBeNil)
+			So(cfg.database, ShouldEqual, "test")
+			So(logger, ShouldNotBeNil)
+			So(cache.entries, ShouldHaveLength, 1)
+
+			_, again, err := cache.get(plugin.Config{
+				"isMultiFields": false,
+				"skip-verify":   false,
+				"scheme":        HTTP,
+				"retention":     "autogen",
+				"database":      "test",
+				"port":          int64(8086),
+				"host":          "localhost",
+			})
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, logger)
+			So(cache.entries, ShouldHaveLength, 1)
+		})
+
+		Convey("distinct configurations are parsed separately", func() {
+			_, _, err := cache.get(config)
+			So(err, ShouldBeNil)
+			config["database"] = "other"
+			cfg, _, err := cache.get(config)
+			So(err, ShouldBeNil)
+			So(cfg.database, ShouldEqual, "other")
+			So(cache.entries, ShouldHaveLength, 2)
+		})
+
+		Convey("invalid configurations are not cached", func() {
+			config["port"] = int64(0)
+			_, _, err := cache.get(config)
+			So(err, ShouldNotBeNil)
+			So(cache.entries, ShouldBeEmpty)
+		})
+
+		Convey("credentials read from files are resolved again", func() {
+			dir, err := ioutil.TempDir("", "influxdb")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "password")
+			So(ioutil.WriteFile(path, []byte("first"), 0600), ShouldBeNil)
+
+			config["user"] = "admin"
+			config["password-file"] = path
+			cfg, _, err := cache.get(config)
+			So(err, ShouldBeNil)
+			So(cfg.password, ShouldEqual, "first")
+
+			So(ioutil.WriteFile(path, []byte("second!"), 0600), ShouldBeNil)
+			cfg, _, err = cache.get(config)
+			So(err, ShouldBeNil)
+			So(cfg.password, ShouldEqual, "second!")
+			So(cache.entries, ShouldHaveLength, 1)
+		})
+	})
+}
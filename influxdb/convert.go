@@ -0,0 +1,119 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+// NewConfig builds the configuration of the publisher from values read from a file, as snapteld does from a task:
+// values are converted to the type of their item and missing items take their default.
+// Values of unknown items are kept as they are.
+func NewConfig(values map[string]interface{}) (plugin.Config, error) {
+	config := plugin.Config{}
+	for k, v := range values {
+		config[k] = v
+	}
+	errs := configErrors{}
+	for _, item := range configItems {
+		v, ok := values[item.key]
+		if !ok {
+			if !item.required && !item.optional {
+				config[item.key] = item.def
+			}
+			continue
+		}
+		value, err := convertValue(v, item.def)
+		if err != nil {
+			errs.add("invalid value for %s: %s", item.key, err)
+			continue
+		}
+		config[item.key] = value
+	}
+	return config, errs.err()
+}
+
+// convertValue converts v to the type of def
+func convertValue(v, def interface{}) (interface{}, error) {
+	s := fmt.Sprint(v)
+	switch def.(type) {
+	case bool:
+		return strconv.ParseBool(s)
+	case int64:
+		if f, ok := v.(float64); ok && f == float64(int64(f)) {
+			return int64(f), nil
+		}
+		return strconv.ParseInt(s, 10, 64)
+	case float64:
+		return strconv.ParseFloat(s, 64)
+	}
+	return s, nil
+}
+
+// Convert writes the line protocol of the points metrics are converted to, as when they are published,
+// each batch being preceded by a header naming its destination. Nothing is written to InfluxDB.
+func Convert(w io.Writer, metrics []plugin.Metric, pluginConfig plugin.Config) error {
+	config, err := getConfig(pluginConfig)
+	if err != nil {
+		return err
+	}
+	config.dryRun = true
+	logger := getLogger(config)
+
+	points := processMetrics(metrics, config, logger)
+	// Points grouped into multiple fields come in no particular order, sort them so that outputs can be compared
+	sort.Sort(sortedPoints(points))
+	batches, destinations, err := batchPoints(points, config, logger)
+	if err != nil {
+		return err
+	}
+	for _, dest := range destinations {
+		if _, err := w.Write(batchHeader(batches[dest])); err != nil {
+			return err
+		}
+		if _, err := w.Write(lineProtocol(batches[dest])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedPoints sorts points by destination, measurement, tags and time
+type sortedPoints []point
+
+func (s sortedPoints) Len() int      { return len(s) }
+func (s sortedPoints) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sortedPoints) Less(i, j int) bool {
+	if s[i].dest != s[j].dest {
+		if s[i].dest.database != s[j].dest.database {
+			return s[i].dest.database < s[j].dest.database
+		}
+		return s[i].dest.retention < s[j].dest.retention
+	}
+	if ki, kj := s[i].seriesKey(), s[j].seriesKey(); ki != kj {
+		return ki < kj
+	}
+	return s[i].ts.Before(s[j].ts)
+}
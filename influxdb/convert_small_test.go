@@ -0,0 +1,106 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+func TestConvert(t *testing.T) {
+	Convey("Given values read from a file", t, func() {
+		values := map[string]interface{}{
+			"host":                "localhost",
+			"database":            "test",
+			"port":                "8087",
+			"skip-verify":         "true",
+			"debug-dump-sampling": float64(1),
+			"log-level":           "debug",
+		}
+
+		Convey("they are converted to the type of their item", func() {
+			config, err := NewConfig(values)
+			So(err, ShouldBeNil)
+			So(config["port"], ShouldEqual, int64(8087))
+			So(config["skip-verify"], ShouldEqual, true)
+			So(config["debug-dump-sampling"], ShouldEqual, float64(1))
+			So(config["log-level"], ShouldEqual, "debug")
+		})
+
+		Convey("missing items take their default", func() {
+			config, err := NewConfig(values)
+			So(err, ShouldBeNil)
+			So(config["scheme"], ShouldEqual, HTTP)
+			So(config["max-series"], ShouldEqual, int64(0))
+			_, ok := config["user"]
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("invalid values are reported", func() {
+			values["port"] = "http"
+			_, err := NewConfig(values)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("metrics are converted to line protocol", func() {
+			config, err := NewConfig(values)
+			So(err, ShouldBeNil)
+			config["precision"] = "s"
+			buf := &bytes.Buffer{}
+			err = Convert(buf, []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "cpu", "idle"), Timestamp: time.Unix(1500000000, 0), Data: 1.5},
+			}, config)
+			So(err, ShouldBeNil)
+			So(buf.String(), ShouldEqual, "# batch db=test rp=autogen precision=s\nintel/cpu/idle value=1.5 1500000000\n")
+		})
+
+		Convey("points grouped into multiple fields are printed in order", func() {
+			values["isMultiFields"] = true
+			values["routes"] = "/intel/mem/* => mem"
+			config, err := NewConfig(values)
+			So(err, ShouldBeNil)
+			config["precision"] = "s"
+			ts := time.Unix(1500000000, 0)
+			metrics := []plugin.Metric{
+				{Namespace: plugin.NewNamespace("intel", "mem", "free"), Timestamp: ts, Data: 3.0},
+				{Namespace: plugin.NewNamespace("intel", "load", "load5"), Timestamp: ts.Add(time.Second), Data: 2.0},
+				{Namespace: plugin.NewNamespace("intel", "load", "load1"), Timestamp: ts.Add(time.Second), Data: 1.0},
+				{Namespace: plugin.NewNamespace("intel", "cpu", "idle"), Timestamp: ts, Data: 1.5},
+				{Namespace: plugin.NewNamespace("intel", "cpu", "user"), Timestamp: ts, Data: 2.5},
+			}
+			expected := "# batch db=mem rp=autogen precision=s\n" +
+				"intel/mem free=3 1500000000\n" +
+				"# batch db=test rp=autogen precision=s\n" +
+				"intel/cpu idle=1.5,user=2.5 1500000000\n" +
+				"intel/load load1=1,load5=2 1500000001\n"
+			for i := 0; i < 10; i++ {
+				buf := &bytes.Buffer{}
+				So(Convert(buf, metrics, config), ShouldBeNil)
+				So(buf.String(), ShouldEqual, expected)
+			}
+		})
+	})
+}
@@ -0,0 +1,140 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// How long the last value of a counter is kept once it stops being published
+	counterStateTTL = time.Hour
+	// Last values of counters by destination and series
+	counters = newCounterTracker()
+)
+
+// counterTracker keeps the previous value of every counter series to derive rates
+type counterTracker struct {
+	last      map[string]counterSample
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+type counterSample struct {
+	value interface{}
+	ts    time.Time
+	seen  time.Time
+}
+
+func newCounterTracker() *counterTracker {
+	return &counterTracker{
+		last:      make(map[string]counterSample),
+		lastPrune: time.Now(),
+	}
+}
+
+// isCounter checks if the namespace of a metric matches one of the counter patterns
+func isCounter(ns []string, patterns []string) bool {
+	name := "/" + strings.Join(ns, "/")
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rate returns the per second rate of a counter since its previous sample.
+// It returns false when no rate can be derived: on the first sample of a series,
+// for non numeric values or when the timestamp did not move forward.
+func (c *counterTracker) rate(key string, value interface{}, ts time.Time) (float64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPrune) > counterStateTTL {
+		for k, s := range c.last {
+			if now.Sub(s.seen) > counterStateTTL {
+				delete(c.last, k)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	if _, ok := toFloat(value); !ok {
+		return 0, false
+	}
+
+	prev, ok := c.last[key]
+	if ok && !ts.After(prev.ts) {
+		return 0, false
+	}
+	c.last[key] = counterSample{value: value, ts: ts, seen: now}
+	if !ok {
+		return 0, false
+	}
+
+	return counterDelta(prev.value, value) / ts.Sub(prev.ts).Seconds(), true
+}
+
+// counterDelta returns the increase of a counter between two samples.
+// A decreasing unsigned counter which was in the upper half of its range is considered
+// to have wrapped around, any other decrease is a reset and the counter restarted from zero.
+func counterDelta(prev, cur interface{}) float64 {
+	p, pmax, pok := toUint(prev)
+	c, cmax, cok := toUint(cur)
+	if pok && cok && pmax == cmax {
+		switch {
+		case c >= p:
+			return float64(c - p)
+		case p > pmax/2 && c <= pmax/2:
+			return float64(pmax-p) + float64(c) + 1
+		default:
+			return float64(c)
+		}
+	}
+
+	pf, _ := toFloat(prev)
+	cf, _ := toFloat(cur)
+	if cf >= pf {
+		return cf - pf
+	}
+	return cf
+}
+
+// toUint converts unsigned values to uint64 along with the maximum value of their type
+func toUint(v interface{}) (uint64, uint64, bool) {
+	switch n := v.(type) {
+	case uint:
+		return uint64(n), uint64(^uint(0)), true
+	case uint8:
+		return uint64(n), uint64(^uint8(0)), true
+	case uint16:
+		return uint64(n), uint64(^uint16(0)), true
+	case uint32:
+		return uint64(n), uint64(^uint32(0)), true
+	case uint64:
+		return n, ^uint64(0), true
+	}
+	return 0, 0, false
+}
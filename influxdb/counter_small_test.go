@@ -0,0 +1,91 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+func TestCounterRate(t *testing.T) {
+	Convey("Given a counter tracker", t, func() {
+		c := newCounterTracker()
+		start := time.Now()
+
+		Convey("the first sample does not produce a rate", func() {
+			_, ok := c.rate("key", uint64(100), start)
+			So(ok, ShouldBeFalse)
+
+			Convey("the next one produces a per second rate", func() {
+				r, ok := c.rate("key", uint64(300), start.Add(10*time.Second))
+				So(ok, ShouldBeTrue)
+				So(r, ShouldEqual, 20.0)
+			})
+
+			Convey("samples which do not move forward in time are ignored", func() {
+				_, ok := c.rate("key", uint64(300), start)
+				So(ok, ShouldBeFalse)
+			})
+		})
+
+		Convey("non numeric values do not produce a rate", func() {
+			c.rate("key", "foo", start)
+			_, ok := c.rate("key", "bar", start.Add(time.Second))
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("Counter deltas handle resets and wraparound", t, func() {
+		So(counterDelta(uint64(10), uint64(15)), ShouldEqual, 5.0)
+		So(counterDelta(^uint64(0)-4, uint64(5)), ShouldEqual, 10.0)
+		So(counterDelta(^uint32(0), uint32(0)), ShouldEqual, 1.0)
+		So(counterDelta(uint64(1000), uint64(5)), ShouldEqual, 5.0)
+		So(counterDelta(int64(1000), int64(5)), ShouldEqual, 5.0)
+		So(counterDelta(2.5, 4.0), ShouldEqual, 1.5)
+	})
+
+	Convey("Counters are selected by namespace patterns", t, func() {
+		patterns := []string{"/intel/psutil/net/*/bytes_recv"}
+		So(isCounter([]string{"intel", "psutil", "net", "eth0", "bytes_recv"}, patterns), ShouldBeTrue)
+		So(isCounter([]string{"intel", "psutil", "net", "eth0", "bytes_sent"}, patterns), ShouldBeFalse)
+
+		_, err := getPatterns(plugin.Config{"counters": "/intel/[a"}, "counters")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Publishing counters adds a rate field", t, func() {
+		config := configuration{database: "rates", unitMode: MetaTag, counters: []string{"/intel/net/*"}}
+		start := time.Now()
+		metric := func(ts time.Time, value uint64) []plugin.Metric {
+			return []plugin.Metric{{Namespace: plugin.NewNamespace("intel", "net", "rx"), Timestamp: ts, Data: value}}
+		}
+		points := convertMetrics(metric(start, 10), config, log.WithField("test", "counter"))
+		So(points[0].fields, ShouldNotContainKey, "rate")
+		points = convertMetrics(metric(start.Add(2*time.Second), 20), config, log.WithField("test", "counter"))
+		So(points[0].fields["rate"], ShouldEqual, 5.0)
+		So(points[0].fields["value"], ShouldEqual, int64(20))
+	})
+}
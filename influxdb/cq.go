@@ -0,0 +1,149 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// provisioning declares the retention policies and continuous queries installed on every database the plugin writes to
+type provisioning struct {
+	RetentionPolicies []struct {
+		Name          string `json:"name"`
+		Duration      string `json:"duration"`
+		Replication   int64  `json:"replication"`
+		ShardDuration string `json:"shard_duration"`
+		Default       bool   `json:"default"`
+	} `json:"retention_policies"`
+	ContinuousQueries []struct {
+		Name     string `json:"name"`
+		Resample string `json:"resample"`
+		Query    string `json:"query"`
+	} `json:"continuous_queries"`
+}
+
+// loadProvisioning reads and validates a provisioning file
+func loadProvisioning(path string) (provisioning, error) {
+	p := provisioning{}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("invalid continuous queries file %s: %s", path, err)
+	}
+
+	for _, rp := range p.RetentionPolicies {
+		if rp.Name == "" {
+			return p, fmt.Errorf("invalid continuous queries file %s: retention policy without name", path)
+		}
+		if _, err := parseInfluxDuration(rp.Duration); err != nil {
+			return p, fmt.Errorf("invalid continuous queries file %s: retention policy %s: %s", path, rp.Name, err)
+		}
+	}
+	for _, cq := range p.ContinuousQueries {
+		if cq.Name == "" || cq.Query == "" {
+			return p, fmt.Errorf("invalid continuous queries file %s: continuous query without name or query", path)
+		}
+	}
+	return p, nil
+}
+
+// Get the names of the continuous queries of a database
+func (c *clientConnection) continuousQueries(db string) (map[string]bool, error) {
+	resp, err := c.query("GET", "SHOW CONTINUOUS QUERIES")
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for _, r := range resp.Results {
+		for _, s := range r.Series {
+			// There is one series per database
+			if s.Name != db {
+				continue
+			}
+			for _, v := range s.Values {
+				for i, c := range s.Columns {
+					if c == "name" && i < len(v) {
+						if name, ok := v[i].(string); ok {
+							names[name] = true
+						}
+					}
+				}
+			}
+		}
+	}
+	return names, nil
+}
+
+// Install the retention policies and the continuous queries of the provisioning file which don't exist yet on a database.
+// "{database}" in queries is replaced with the name of the database.
+func (c *clientConnection) provision(db string, p provisioning, logger *log.Entry) error {
+	for _, rp := range p.RetentionPolicies {
+		rs := retentionSettings{
+			name:          rp.Name,
+			duration:      rp.Duration,
+			replication:   rp.Replication,
+			shardDuration: rp.ShardDuration,
+			isDefault:     rp.Default,
+		}
+		if rs.replication < 1 {
+			rs.replication = 1
+		}
+		if err := c.ensureRetentionPolicy(db, rs, logger); err != nil {
+			return err
+		}
+	}
+
+	if len(p.ContinuousQueries) == 0 {
+		return nil
+	}
+	existing, err := c.continuousQueries(db)
+	if err != nil {
+		return err
+	}
+	for _, cq := range p.ContinuousQueries {
+		if existing[cq.Name] {
+			continue
+		}
+
+		stmt := fmt.Sprintf("CREATE CONTINUOUS QUERY %s ON %s", quoteIdent(cq.Name), quoteIdent(db))
+		if cq.Resample != "" {
+			stmt += " RESAMPLE " + strings.TrimPrefix(strings.TrimSpace(cq.Resample), "RESAMPLE ")
+		}
+		stmt += " BEGIN " + strings.Replace(cq.Query, "{database}", db, -1) + " END"
+
+		logger.WithFields(log.Fields{
+			"database":         db,
+			"continuous-query": cq.Name,
+		}).Info("Creating continuous query")
+		if _, err := c.query("POST", stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestContinuousQueries(t *testing.T) {
+	Convey("Given a provisioning file", t, func() {
+		dir, _ := ioutil.TempDir("", "snap-influxdb")
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "cq.json")
+		ioutil.WriteFile(file, []byte(`{
+			"retention_policies": [{"name": "long", "duration": "52w"}],
+			"continuous_queries": [
+				{"name": "cq_5m", "resample": "EVERY 10m", "query": "SELECT mean(*) INTO \"{database}\".\"long\".:MEASUREMENT FROM /.*/ GROUP BY time(5m), *"},
+				{"name": "cq_1h", "query": "SELECT mean(*) INTO \"long\".:MEASUREMENT FROM /.*/ GROUP BY time(1h), *"}
+			]
+		}`), 0600)
+
+		queries := []string{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query().Get("q")
+			queries = append(queries, q)
+			switch q {
+			case `SHOW RETENTION POLICIES ON "test"`:
+				w.Write([]byte(`{"results":[{"series":[{"columns":["name","duration","shardGroupDuration","replicaN","default"],"values":[["long","8736h0m0s","168h0m0s",1,false]]}]}]}`))
+			case "SHOW CONTINUOUS QUERIES":
+				w.Write([]byte(`{"results":[{"series":[{"name":"_internal","columns":["name","query"]},{"name":"test","columns":["name","query"],"values":[["cq_1h","CREATE CONTINUOUS QUERY ..."]]}]}]}`))
+			default:
+				w.Write([]byte(`{"results":[{}]}`))
+			}
+		}))
+		defer ts.Close()
+		u, _ := url.Parse(ts.URL)
+
+		Convey("only missing continuous queries are created", func() {
+			p, err := loadProvisioning(file)
+			So(err, ShouldBeNil)
+			c := &clientConnection{url: u}
+			err = c.provision("test", p, log.WithField("test", "cq"))
+			So(err, ShouldBeNil)
+			So(queries, ShouldResemble, []string{
+				`SHOW RETENTION POLICIES ON "test"`,
+				"SHOW CONTINUOUS QUERIES",
+				`CREATE CONTINUOUS QUERY "cq_5m" ON "test" RESAMPLE EVERY 10m BEGIN SELECT mean(*) INTO "test"."long".:MEASUREMENT FROM /.*/ GROUP BY time(5m), * END`,
+			})
+		})
+	})
+
+	Convey("Invalid provisioning files are rejected", t, func() {
+		dir, _ := ioutil.TempDir("", "snap-influxdb")
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "cq.json")
+
+		ioutil.WriteFile(file, []byte(`{"continuous_queries": [{"name": "cq"}]}`), 0600)
+		_, err := loadProvisioning(file)
+		So(err, ShouldNotBeNil)
+
+		_, err = loadProvisioning(filepath.Join(dir, "missing.json"))
+		So(err, ShouldNotBeNil)
+
+		Convey("along with the other problems of the configuration", func() {
+			config := plugin.Config{
+				"host":               "localhost",
+				"port":               int64(8086),
+				"database":           "test",
+				"retention":          "autogen",
+				"scheme":             HTTP,
+				"skip-verify":        false,
+				"isMultiFields":      false,
+				"precision":          "x",
+				"continuous-queries": file,
+			}
+			_, err := getConfig(config)
+			So(err, ShouldNotBeNil)
+			So(err.(configErrors), ShouldHaveLength, 2)
+			So(err.Error(), ShouldContainSubstring, "invalid continuous queries file")
+
+			ioutil.WriteFile(file, []byte(`{"continuous_queries": [{"name": "cq", "query": "SELECT 1"}]}`), 0600)
+			config["precision"] = "s"
+			cfg, err := getConfig(config)
+			So(err, ShouldBeNil)
+			So(cfg.provisioning.ContinuousQueries, ShouldHaveLength, 1)
+		})
+	})
+}
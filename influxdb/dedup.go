@@ -0,0 +1,140 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DedupNone disables deduplication
+	DedupNone = "none"
+	// DedupFirst keeps the first of duplicate points
+	DedupFirst = "first"
+	// DedupLast keeps the last of duplicate points
+	DedupLast = "last"
+)
+
+var (
+	// Deduplicators by destination and settings
+	deduplicators = make(map[string]*deduplicator)
+	// Mutex for synchronizing deduplicators changes
+	deduplicatorsMutex = &sync.Mutex{}
+)
+
+// deduplicator removes points sharing the same series and timestamp, within a batch and
+// across the batches published during the window
+type deduplicator struct {
+	keep      string
+	window    time.Duration
+	seen      map[string]seenPoint
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+type seenPoint struct {
+	fields string
+	seen   time.Time
+}
+
+// deduplicatorFor returns the deduplicator shared by every task writing to the same destination with the same settings
+func deduplicatorFor(config configuration) *deduplicator {
+	key := fmt.Sprintf("%s/%s/%s", destinationKey(config), config.dedup, config.dedupWindow)
+
+	deduplicatorsMutex.Lock()
+	defer deduplicatorsMutex.Unlock()
+
+	if deduplicators[key] == nil {
+		deduplicators[key] = newDeduplicator(config.dedup, config.dedupWindow)
+	}
+	return deduplicators[key]
+}
+
+func newDeduplicator(keep string, window time.Duration) *deduplicator {
+	return &deduplicator{
+		keep:      keep,
+		window:    window,
+		seen:      make(map[string]seenPoint),
+		lastPrune: time.Now(),
+	}
+}
+
+// filter returns the points without duplicates along with the number of removed points.
+// Within a batch the first or the last point is kept. Across batches a point already
+// published is dropped when keeping the first one, or when its fields did not change
+// when keeping the last one, as InfluxDB overwrites points with the same series and timestamp.
+func (d *deduplicator) filter(points []point, now time.Time) ([]point, int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if now.Sub(d.lastPrune) > d.window/4 {
+		for k, s := range d.seen {
+			if now.Sub(s.seen) > d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastPrune = now
+	}
+
+	out := make([]point, 0, len(points))
+	index := map[string]int{}
+	for _, p := range points {
+		key := fmt.Sprintf("%s%s%d", p.seriesKey(), separator, p.ts.UnixNano())
+		if i, ok := index[key]; ok {
+			if d.keep == DedupLast {
+				out[i] = p
+			}
+			continue
+		}
+		index[key] = len(out)
+		out = append(out, p)
+	}
+
+	kept := make([]point, 0, len(out))
+	for _, p := range out {
+		key := fmt.Sprintf("%s%s%d", p.seriesKey(), separator, p.ts.UnixNano())
+		fields := fieldsFingerprint(p.fields)
+		if s, ok := d.seen[key]; ok && (d.keep == DedupFirst || s.fields == fields) {
+			continue
+		}
+		d.seen[key] = seenPoint{fields: fields, seen: now}
+		kept = append(kept, p)
+	}
+	return kept, len(points) - len(kept)
+}
+
+// fieldsFingerprint returns a string representation of fields, including their types
+func fieldsFingerprint(fields map[string]interface{}) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	elems := make([]string, 0, len(keys))
+	for _, k := range keys {
+		elems = append(elems, fmt.Sprintf("%s=%T:%v", k, fields[k], fields[k]))
+	}
+	return strings.Join(elems, separator)
+}
@@ -0,0 +1,152 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeduplicator(t *testing.T) {
+	now := time.Now()
+	sample := func(value interface{}) point {
+		return point{
+			ns:     []string{"intel", "psutil", "load", "load1"},
+			tags:   map[string]string{"source": "host1"},
+			ts:     now,
+			fields: map[string]interface{}{"value": value},
+		}
+	}
+
+	Convey("Given duplicate points within a batch", t, func() {
+		points := []point{sample(1.0), sample(2.0), sample(3.0)}
+
+		Convey("the first one can be kept", func() {
+			out, removed := newDeduplicator(DedupFirst, time.Minute).filter(points, now)
+			So(removed, ShouldEqual, 2)
+			So(out, ShouldHaveLength, 1)
+			So(out[0].fields["value"], ShouldEqual, 1.0)
+		})
+
+		Convey("the last one can be kept", func() {
+			out, removed := newDeduplicator(DedupLast, time.Minute).filter(points, now)
+			So(removed, ShouldEqual, 2)
+			So(out, ShouldHaveLength, 1)
+			So(out[0].fields["value"], ShouldEqual, 3.0)
+		})
+	})
+
+	Convey("Given points published in a previous batch", t, func() {
+		Convey("identical points are removed", func() {
+			d := newDeduplicator(DedupLast, time.Minute)
+			d.filter([]point{sample(1.0)}, now)
+			out, removed := d.filter([]point{sample(1.0)}, now)
+			So(removed, ShouldEqual, 1)
+			So(out, ShouldBeEmpty)
+
+			Convey("unless they are older than the window", func() {
+				out, _ := d.filter([]point{sample(1.0)}, now.Add(2*time.Minute))
+				So(out, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("changed points are kept when keeping the last one", func() {
+			d := newDeduplicator(DedupLast, time.Minute)
+			d.filter([]point{sample(1.0)}, now)
+			out, _ := d.filter([]point{sample(2.0)}, now)
+			So(out, ShouldHaveLength, 1)
+		})
+
+		Convey("changed points are removed when keeping the first one", func() {
+			d := newDeduplicator(DedupFirst, time.Minute)
+			d.filter([]point{sample(1.0)}, now)
+			out, _ := d.filter([]point{sample(2.0)}, now)
+			So(out, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestMultiFieldsDedup(t *testing.T) {
+	Convey("Given duplicate samples of multiple fields", t, func() {
+		now := time.Now()
+		sample := func(leaf string, ts time.Time, value float64) plugin.Metric {
+			return plugin.Metric{Namespace: plugin.NewNamespace("intel", "psutil", "load", leaf), Timestamp: ts, Data: value}
+		}
+		metrics := []plugin.Metric{
+			sample("load1", now, 1.0),
+			sample("load5", now, 5.0),
+			sample("load1", now, 2.0),
+			sample("load1", now.Add(time.Second), 3.0),
+		}
+		config := configuration{database: "test", retention: "autogen", isMultiFields: true}
+		find := func(points []point, ts time.Time) point {
+			for _, p := range points {
+				if p.ts.Equal(ts) {
+					return p
+				}
+			}
+			return point{}
+		}
+
+		Convey("samples of different timestamps are grouped without deduplication", func() {
+			config.dedup = DedupNone
+			points := convertMetrics(metrics, config, log.WithField("test", "dedup"))
+			So(points, ShouldHaveLength, 1)
+			So(points[0].ts, ShouldResemble, now)
+			So(points[0].fields, ShouldResemble, map[string]interface{}{"load1": 3.0, "load5": 5.0})
+		})
+
+		Convey("the first one can be kept", func() {
+			config.dedup = DedupFirst
+			before := stats.snapshot().dropped[dropDedup]
+			points := convertMetrics(metrics, config, log.WithField("test", "dedup"))
+			So(points, ShouldHaveLength, 2)
+			So(find(points, now).fields, ShouldResemble, map[string]interface{}{"load1": 1.0, "load5": 5.0})
+			So(stats.snapshot().dropped[dropDedup]-before, ShouldEqual, 1)
+		})
+
+		Convey("samples with several tags are grouped whatever the order of their tags", func() {
+			config.dedup = DedupFirst
+			tags := map[string]string{"source": "host1", "unit": "Load/1M", "rack": "r1", "zone": "z1"}
+			for i := range metrics {
+				metrics[i].Tags = tags
+			}
+			for i := 0; i < 20; i++ {
+				points := convertMetrics(metrics, config, log.WithField("test", "dedup"))
+				So(points, ShouldHaveLength, 2)
+				So(find(points, now).fields, ShouldResemble, map[string]interface{}{"load1": 1.0, "load5": 5.0})
+			}
+		})
+
+		Convey("the last one can be kept", func() {
+			config.dedup = DedupLast
+			before := stats.snapshot().dropped[dropDedup]
+			points := convertMetrics(metrics, config, log.WithField("test", "dedup"))
+			So(points, ShouldHaveLength, 2)
+			So(find(points, now).fields, ShouldResemble, map[string]interface{}{"load1": 2.0, "load5": 5.0})
+			So(stats.snapshot().dropped[dropDedup]-before, ShouldEqual, 1)
+		})
+	})
+}
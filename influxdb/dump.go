@@ -0,0 +1,137 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+var (
+	// Dump files by path
+	dumpers = make(map[string]*dumper)
+	// Mutex for synchronizing dump files changes
+	dumpersMutex = &sync.Mutex{}
+)
+
+// dumper writes the line protocol of batches to a file, which is rotated when it reaches its maximum size
+type dumper struct {
+	path     string
+	maxSize  int64
+	backups  int
+	sampling float64
+	// Accumulates the sampling rate, a batch is dumped each time it reaches 1
+	credit float64
+	file   *os.File
+	size   int64
+	mutex  sync.Mutex
+}
+
+// dumperFor returns the dumper shared by every task dumping to the same file
+func dumperFor(config configuration) *dumper {
+	dumpersMutex.Lock()
+	defer dumpersMutex.Unlock()
+
+	d := dumpers[config.debugDump]
+	if d == nil {
+		d = &dumper{path: config.debugDump}
+		dumpers[config.debugDump] = d
+	}
+	d.mutex.Lock()
+	d.maxSize = config.debugDumpMaxSize
+	d.backups = config.debugDumpBackups
+	d.sampling = config.debugDumpSampling
+	d.mutex.Unlock()
+	return d
+}
+
+// lineProtocol encodes batch points in the line protocol, one point per line
+func lineProtocol(bps client.BatchPoints) []byte {
+	var buf bytes.Buffer
+	for _, p := range bps.Points() {
+		buf.WriteString(p.PrecisionString(bps.Precision()))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// batchHeader returns the comment line naming the destination of batch points, e.g.
+// "# batch db=test rp=autogen precision=s"
+func batchHeader(bps client.BatchPoints) []byte {
+	return []byte(fmt.Sprintf("# batch db=%s rp=%s precision=%s\n", bps.Database(), bps.RetentionPolicy(), bps.Precision()))
+}
+
+// dump writes batch points preceded by their header, unless the batch is not sampled
+func (d *dumper) dump(bps client.BatchPoints) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.credit += d.sampling
+	if d.credit < 1 {
+		return nil
+	}
+	d.credit--
+
+	var buf bytes.Buffer
+	buf.Write(batchHeader(bps))
+	buf.Write(lineProtocol(bps))
+
+	if d.file != nil && d.size > 0 && d.size+int64(buf.Len()) > d.maxSize {
+		if err := d.rotate(); err != nil {
+			return err
+		}
+	}
+	if d.file == nil {
+		f, err := os.OpenFile(d.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		d.file, d.size = f, info.Size()
+	}
+
+	n, err := d.file.Write(buf.Bytes())
+	d.size += int64(n)
+	return err
+}
+
+// rotate closes the file and renames it to path.1, shifting older backups, the oldest one is removed
+func (d *dumper) rotate() error {
+	if err := d.file.Close(); err != nil {
+		return err
+	}
+	d.file, d.size = nil, 0
+
+	if d.backups == 0 {
+		return os.Remove(d.path)
+	}
+	for i := d.backups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", d.path, i), fmt.Sprintf("%s.%d", d.path, i+1))
+	}
+	return os.Rename(d.path, d.path+".1")
+}
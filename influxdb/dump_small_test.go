@@ -0,0 +1,84 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDump(t *testing.T) {
+	Convey("Given a dumper", t, func() {
+		dir, err := ioutil.TempDir("", "influxdb")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "dump.lp")
+		d := &dumper{path: path, maxSize: 1024 * 1024, backups: 2, sampling: 1}
+		defer func() {
+			if d.file != nil {
+				d.file.Close()
+			}
+		}()
+
+		bps, err := client.NewBatchPoints(client.BatchPointsConfig{Database: "test", RetentionPolicy: "autogen", Precision: "s"})
+		So(err, ShouldBeNil)
+		pt, err := client.NewPoint("intel/cpu/idle", map[string]string{"source": "host1"}, map[string]interface{}{"value": 1.5}, time.Unix(1500000000, 0))
+		So(err, ShouldBeNil)
+		bps.AddPoint(pt)
+
+		Convey("batches are written with a header", func() {
+			So(d.dump(bps), ShouldBeNil)
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "# batch db=test rp=autogen precision=s\nintel/cpu/idle,source=host1 value=1.5 1500000000\n")
+		})
+
+		Convey("batches are sampled", func() {
+			d.sampling = 0.5
+			for i := 0; i < 4; i++ {
+				So(d.dump(bps), ShouldBeNil)
+			}
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 2*len("# batch db=test rp=autogen precision=s\nintel/cpu/idle,source=host1 value=1.5 1500000000\n"))
+		})
+
+		Convey("the file is rotated when it reaches its maximum size", func() {
+			d.maxSize = 100
+			for i := 0; i < 4; i++ {
+				So(d.dump(bps), ShouldBeNil)
+			}
+			for _, name := range []string{"dump.lp", "dump.lp.1", "dump.lp.2"} {
+				_, err := os.Stat(filepath.Join(dir, name))
+				So(err, ShouldBeNil)
+			}
+			_, err := os.Stat(filepath.Join(dir, "dump.lp.3"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
@@ -20,13 +20,18 @@ limitations under the License.
 package influxdb
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -44,31 +49,51 @@ const (
 
 	// HTTP represents its string constant
 	HTTP = "http"
+	// HTTPS represents its string constant
+	HTTPS = "https"
 	// UDP represents its string constant
 	UDP = "udp"
-)
 
-var (
-	// The maximum time a connection can sit around unused.
-	maxConnectionIdle = time.Minute * 30
-	// How frequently idle connections are checked
-	watchConnectionWait = time.Minute * 15
-	// Our connection pool
-	connPool = make(map[string]*clientConnection)
-	// Mutex for synchronizing connection pool changes
-	m           = &sync.Mutex{}
-	initialized = false
+	// MetaTag stores metric metadata (unit, description) as tags
+	MetaTag = "tag"
+	// MetaField stores metric metadata (unit, description) as fields
+	MetaField = "field"
+	// MetaNone drops metric metadata (unit, description)
+	MetaNone = "none"
+
+	// AuthAuto selects token authentication when a token is set, basic authentication when a user is set
+	AuthAuto = "auto"
+	// AuthNone disables authentication
+	AuthNone = "none"
+	// AuthBasic authenticates with user and password
+	AuthBasic = "basic"
+	// AuthToken authenticates with a token
+	AuthToken = "token"
+
+	// CreateDatabase creates the database when it doesn't exist
+	CreateDatabase = "true"
+	// CreateDatabaseNever neither checks nor creates the database
+	CreateDatabaseNever = "false"
+	// CreateDatabaseVerifyOnly fails when the database doesn't exist
+	CreateDatabaseVerifyOnly = "verify-only"
 )
 
-func init() {
-	go watchConnections()
-}
-
 //NewInfluxPublisher returns an instance of the InfluxDB publisher
 func NewInfluxPublisher() *InfluxPublisher {
 	return &InfluxPublisher{}
 }
 
+// Close writes the aggregates waiting for their window to complete, stops writing and serving
+// the statistics and closes the connections to InfluxDB, they are started again when publishing
+func (ip *InfluxPublisher) Close() error {
+	parsedConfigs.clear()
+	stopStatsWriters()
+	flushAggregates()
+	stopPrometheus()
+	pool.close()
+	return nil
+}
+
 type InfluxPublisher struct {
 }
 
@@ -77,46 +102,116 @@ type point struct {
 	tags   map[string]string
 	ts     time.Time
 	fields map[string]interface{}
+	dest   destination
+}
+
+// destination is the database and retention policy a point is written to
+type destination struct {
+	database, retention string
+}
+
+// destinationKey identifies the database and retention policy points are written to.
+// Dry runs don't share the state of aggregations, counters, deduplication and cardinality with actual writes.
+func destinationKey(config configuration) string {
+	key := fmt.Sprintf("%s:%d/%s/%s", config.host, config.port, config.database, config.retention)
+	if config.dryRun {
+		return "dry-run:" + key
+	}
+	return key
+}
+
+// seriesKey identifies the series of a point by its measurement and tags
+func (p point) seriesKey() string {
+	keys := make([]string, 0, len(p.tags))
+	for k := range p.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	elems := []string{strings.Join(p.ns, "/")}
+	for _, k := range keys {
+		elems = append(elems, k, p.tags[k])
+	}
+	return strings.Join(elems, separator)
 }
 
 type configuration struct {
 	host, database, user, password, retention, precision, scheme, logLevel string
+	token, auth, task                                                      string
+	unitMode, descriptionMode                                              string
 	port                                                                   int64
-	skipVerify, isMultiFields                                              bool
+	skipVerify, isMultiFields, dryRun                                      bool
+	aggregateWindow                                                        time.Duration
+	counters                                                               []string
+	maxSeries                                                              int64
+	cardinalityAction                                                      string
+	cardinalityDecay                                                       time.Duration
+	dedup                                                                  string
+	dedupWindow                                                            time.Duration
+	routes                                                                 []route
+	databaseAllow                                                          []string
+	retentionPolicy                                                        retentionSettings
+	continuousQueries                                                      string
+	provisioning                                                           *provisioning
+	createDatabase                                                         string
+	statsDatabase                                                          string
+	statsInterval                                                          time.Duration
+	connectionIdleTimeout                                                  time.Duration
+	prometheusListen                                                       string
+	debugDump                                                              string
+	debugDumpMaxSize                                                       int64
+	debugDumpBackups                                                       int
+	debugDumpSampling                                                      float64
 }
 
+// getConfig reads and validates the configuration, every problem found is
+// reported in the returned error
 func getConfig(config plugin.Config) (configuration, error) {
 	cfg := configuration{}
+	errs := configErrors{}
 	var err error
 
 	cfg.host, err = config.GetString("host")
 	if err != nil {
-		return cfg, fmt.Errorf("%s: %s", err, "host")
+		errs.add("host is required")
 	}
 
 	cfg.database, err = config.GetString("database")
 	if err != nil {
-		return cfg, fmt.Errorf("%s: %s", err, "database")
+		errs.add("database is required")
 	}
 
 	cfg.user, err = config.GetString("user")
-	if err != nil {
-		return cfg, fmt.Errorf("%s: %s", err, "user")
+	if err == nil {
+		cfg.user, err = expandEnv(cfg.user, "user")
+		errs.check(err)
 	}
 
-	cfg.password, err = config.GetString("password")
-	if err != nil {
-		return cfg, fmt.Errorf("%s: %s", err, "password")
-	}
+	cfg.password, err = getSecret(config, "password")
+	errs.check(err)
+
+	cfg.token, err = getSecret(config, "token")
+	errs.check(err)
 
 	cfg.retention, err = config.GetString("retention")
 	if err != nil {
-		return cfg, fmt.Errorf("%s: %s", err, "retention")
+		errs.add("retention is required")
 	}
 
 	cfg.scheme, err = config.GetString("scheme")
 	if err != nil {
-		return cfg, fmt.Errorf("%s: %s", err, "scheme")
+		errs.add("scheme is required")
+	}
+
+	cfg.auth, err = getAuth(config, cfg)
+	errs.check(err)
+
+	cfg.precision, err = config.GetString("precision")
+	if err != nil || cfg.precision == "" {
+		cfg.precision = "ns"
+	}
+	if p, ok := precisionAliases[cfg.precision]; ok {
+		cfg.precision = p
 	}
 
 	cfg.logLevel, err = config.GetString("log-level")
@@ -124,191 +219,601 @@ func getConfig(config plugin.Config) (configuration, error) {
 		cfg.logLevel = "undefined"
 	}
 
+	cfg.task, err = config.GetString("task")
+	if err != nil {
+		cfg.task = ""
+	}
+
 	cfg.port, err = config.GetInt("port")
 	if err != nil {
-		return cfg, fmt.Errorf("%s: %s", err, "port")
+		errs.add("port is required")
 	}
 
 	cfg.skipVerify, err = config.GetBool("skip-verify")
 	if err != nil {
-		return cfg, fmt.Errorf("%s: %s", err, "skip-verify")
+		errs.add("skip-verify is required")
 	}
 
 	cfg.isMultiFields, err = config.GetBool("isMultiFields")
 	if err != nil {
-		return cfg, fmt.Errorf("%s: %s", err, "isMultiFields")
+		errs.add("isMultiFields is required")
+	}
+
+	cfg.unitMode, err = getMetaMode(config, "unit-mode", MetaTag)
+	errs.check(err)
+
+	cfg.descriptionMode, err = getMetaMode(config, "description-mode", MetaNone)
+	errs.check(err)
+
+	cfg.aggregateWindow, err = getDuration(config, "aggregate-window")
+	errs.check(err)
+
+	cfg.counters, err = getPatterns(config, "counters")
+	errs.check(err)
+
+	cfg.maxSeries, err = config.GetInt("max-series")
+	if err != nil {
+		cfg.maxSeries = 0
+	}
+	if cfg.maxSeries < 0 {
+		errs.add("invalid value for %s: %d", "max-series", cfg.maxSeries)
+	}
+
+	cfg.cardinalityAction, err = config.GetString("cardinality-action")
+	if err != nil {
+		cfg.cardinalityAction = CardinalityDrop
+	}
+	if cfg.cardinalityAction != CardinalityDrop && cfg.cardinalityAction != CardinalityStripTags {
+		errs.add("invalid value for %s: %s", "cardinality-action", cfg.cardinalityAction)
+	}
+
+	cfg.cardinalityDecay, err = getDuration(config, "cardinality-decay")
+	errs.check(err)
+	if cfg.cardinalityDecay == 0 {
+		cfg.cardinalityDecay = time.Hour
+	}
+
+	cfg.dedup, err = config.GetString("dedup")
+	if err != nil {
+		cfg.dedup = DedupNone
+	}
+	if cfg.dedup != DedupNone && cfg.dedup != DedupFirst && cfg.dedup != DedupLast {
+		errs.add("invalid value for %s: %s", "dedup", cfg.dedup)
+	}
+
+	cfg.dedupWindow, err = getDuration(config, "dedup-window")
+	errs.check(err)
+	if cfg.dedupWindow == 0 {
+		cfg.dedupWindow = 5 * time.Minute
+	}
+
+	routes, err := config.GetString("routes")
+	if err == nil {
+		cfg.routes, err = parseRoutes(routes)
+		errs.check(err)
+	}
+
+	cfg.databaseAllow, err = getPatterns(config, "database-allow")
+	errs.check(err)
+	if len(cfg.databaseAllow) == 0 && isTemplate(cfg.database) {
+		errs.add("database-allow is required when database is a template: %s", cfg.database)
+	}
+	for _, r := range cfg.routes {
+		if len(cfg.databaseAllow) == 0 && isTemplate(r.database) {
+			errs.add("database-allow is required when the database of a route is a template: %s", r.database)
+		}
+	}
+
+	cfg.retentionPolicy, err = getRetentionSettings(config, cfg.retention)
+	errs.check(err)
+
+	cfg.continuousQueries, err = config.GetString("continuous-queries")
+	if err != nil {
+		cfg.continuousQueries = ""
 	}
 
-	return cfg, nil
+	cfg.createDatabase, err = config.GetString("create-database")
+	if err != nil {
+		cfg.createDatabase = CreateDatabase
+		// Also accept a boolean as in `create-database: false`
+		if create, err := config.GetBool("create-database"); err == nil && !create {
+			cfg.createDatabase = CreateDatabaseNever
+		}
+	}
+	switch cfg.createDatabase {
+	case CreateDatabase, CreateDatabaseNever, CreateDatabaseVerifyOnly:
+	default:
+		errs.add("invalid value for %s: %s", "create-database", cfg.createDatabase)
+	}
+
+	cfg.statsDatabase, err = config.GetString("stats-database")
+	if err != nil {
+		cfg.statsDatabase = ""
+	}
+
+	cfg.statsInterval, err = getDuration(config, "stats-interval")
+	errs.check(err)
+	if cfg.statsInterval == 0 {
+		cfg.statsInterval = time.Minute
+	}
+
+	cfg.connectionIdleTimeout, err = getDuration(config, "connection-idle-timeout")
+	errs.check(err)
+	if cfg.connectionIdleTimeout == 0 {
+		cfg.connectionIdleTimeout = defaultConnectionIdle
+	}
+
+	cfg.prometheusListen, err = config.GetString("prometheus-listen")
+	if err != nil {
+		cfg.prometheusListen = ""
+	}
+
+	cfg.debugDump, err = config.GetString("debug-dump")
+	if err != nil {
+		cfg.debugDump = ""
+	}
+
+	maxSize, err := config.GetInt("debug-dump-max-size")
+	if err != nil {
+		maxSize = 10
+	}
+	cfg.debugDumpMaxSize = maxSize * 1024 * 1024
+
+	backups, err := config.GetInt("debug-dump-backups")
+	if err != nil {
+		backups = 3
+	}
+	cfg.debugDumpBackups = int(backups)
+
+	cfg.debugDumpSampling, err = config.GetFloat("debug-dump-sampling")
+	if err != nil {
+		cfg.debugDumpSampling = 1
+	}
+
+	cfg.dryRun, err = config.GetBool("dry-run")
+	if err != nil {
+		cfg.dryRun = false
+	}
+
+	validate(&cfg, &errs)
+	return cfg, errs.err()
 }
 
-func (ip *InfluxPublisher) GetConfigPolicy() (plugin.ConfigPolicy, error) {
-	policy := plugin.NewConfigPolicy()
+// getAuth returns the authentication mode, checking that its credentials are set.
+// The auto mode is resolved to the mode matching the credentials.
+func getAuth(config plugin.Config, cfg configuration) (string, error) {
+	auth, err := config.GetString("auth")
+	if err != nil {
+		auth = AuthAuto
+	}
+	// Credentials are not sent over UDP
+	if cfg.scheme == UDP {
+		if auth != AuthAuto && auth != AuthNone {
+			return AuthNone, fmt.Errorf("auth %s cannot be used with scheme %s", auth, UDP)
+		}
+		return AuthNone, nil
+	}
+
+	switch auth {
+	case AuthAuto:
+		if cfg.token != "" {
+			return AuthToken, nil
+		}
+		if cfg.user != "" {
+			return AuthBasic, nil
+		}
+		return AuthNone, nil
+	case AuthNone:
+		return auth, nil
+	case AuthBasic:
+		if cfg.user == "" || cfg.password == "" {
+			return auth, fmt.Errorf("auth %s requires user and password or password-file", auth)
+		}
+		return auth, nil
+	case AuthToken:
+		if cfg.token == "" {
+			return auth, fmt.Errorf("auth %s requires token or token-file", auth)
+		}
+		return auth, nil
+	}
+	return auth, fmt.Errorf("invalid value for %s: %s", "auth", auth)
+}
 
-	policy.AddNewStringRule([]string{""}, "host", true)
-	policy.AddNewIntRule([]string{""}, "port", false, plugin.SetDefaultInt(8086))
-	policy.AddNewStringRule([]string{""}, "database", true)
-	policy.AddNewStringRule([]string{""}, "user", true)
-	policy.AddNewStringRule([]string{""}, "password", true)
-	policy.AddNewStringRule([]string{""}, "retention", false, plugin.SetDefaultString("autogen"))
-	policy.AddNewBoolRule([]string{""}, "skip-verify", false, plugin.SetDefaultBool(false))
-	policy.AddNewStringRule([]string{""}, "precision", false, plugin.SetDefaultString("ns"))
-	policy.AddNewBoolRule([]string{""}, "isMultiFields", false, plugin.SetDefaultBool(false))
-	policy.AddNewStringRule([]string{""}, "scheme", false, plugin.SetDefaultString(HTTP))
+// credentials returns the credentials sent according to the authentication mode
+func (c configuration) credentials() credentials {
+	switch c.auth {
+	case AuthBasic:
+		return credentials{user: c.user, password: c.password}
+	case AuthToken:
+		return credentials{token: c.token}
+	}
+	return credentials{}
+}
 
-	return *policy, nil
+// getMetaMode reads one of the metadata modes (tag, field, none) falling back to def when not set
+func getMetaMode(config plugin.Config, key, def string) (string, error) {
+	mode, err := config.GetString(key)
+	if err != nil {
+		return def, nil
+	}
+	switch mode {
+	case MetaTag, MetaField, MetaNone:
+		return mode, nil
+	}
+	return def, fmt.Errorf("invalid value for %s: %s", key, mode)
 }
 
-func watchConnections() {
-	for {
-		time.Sleep(watchConnectionWait)
-		for k, c := range connPool {
-			if time.Now().Sub(c.LastUsed) > maxConnectionIdle {
-				m.Lock()
-				// Close the connection
-				c.closeClientConnection()
-				// Remove from the pool
-				delete(connPool, k)
-				m.Unlock()
+// getDuration reads an optional duration, a missing or empty value means 0
+func getDuration(config plugin.Config, key string) (time.Duration, error) {
+	value, err := config.GetString(key)
+	if err != nil || value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %s", key, value)
+	}
+	return d, nil
+}
+
+// getPatterns reads an optional comma separated list of namespace patterns
+func getPatterns(config plugin.Config, key string) ([]string, error) {
+	value, err := config.GetString(key)
+	if err != nil || value == "" {
+		return nil, nil
+	}
+	patterns := []string{}
+	for _, p := range strings.Split(value, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern in %s: %s", key, p)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// configItem declares an item of the configuration, both the config policy and
+// the configurations read from files by NewConfig are derived from it
+type configItem struct {
+	key      string
+	required bool
+	// Items which are optional have no default, they are missing when not set
+	optional bool
+	// Default value, its type is the type of the item: bool, int64, float64 or string
+	def interface{}
+}
+
+var configItems = []configItem{
+	{key: "host", required: true, def: ""},
+	{key: "port", def: int64(8086)},
+	{key: "database", required: true, def: ""},
+	{key: "auth", def: AuthAuto},
+	{key: "user", optional: true, def: ""},
+	{key: "password", optional: true, def: ""},
+	{key: "password-file", optional: true, def: ""},
+	{key: "token", optional: true, def: ""},
+	{key: "token-file", optional: true, def: ""},
+	{key: "retention", def: "autogen"},
+	{key: "skip-verify", def: false},
+	{key: "precision", def: "ns"},
+	{key: "isMultiFields", def: false},
+	{key: "scheme", def: HTTP},
+	{key: "unit-mode", def: MetaTag},
+	{key: "description-mode", def: MetaNone},
+	{key: "aggregate-window", def: ""},
+	{key: "counters", def: ""},
+	{key: "max-series", def: int64(0)},
+	{key: "cardinality-action", def: CardinalityDrop},
+	{key: "cardinality-decay", def: "1h"},
+	{key: "dedup", def: DedupNone},
+	{key: "dedup-window", def: "5m"},
+	{key: "routes", def: ""},
+	{key: "database-allow", def: ""},
+	{key: "retention-duration", def: ""},
+	{key: "retention-replication", def: int64(1)},
+	{key: "retention-shard-duration", def: ""},
+	{key: "retention-default", def: false},
+	{key: "retention-alter", def: false},
+	{key: "continuous-queries", def: ""},
+	{key: "create-database", def: CreateDatabase},
+	{key: "task", optional: true, def: ""},
+	{key: "stats-database", def: ""},
+	{key: "stats-interval", def: "1m"},
+	{key: "prometheus-listen", def: ""},
+	{key: "debug-dump", def: ""},
+	{key: "debug-dump-max-size", def: int64(10)},
+	{key: "debug-dump-backups", def: int64(3)},
+	{key: "debug-dump-sampling", def: float64(1)},
+	{key: "dry-run", def: false},
+	{key: "connection-idle-timeout", def: "30m"},
+}
+
+func (ip *InfluxPublisher) GetConfigPolicy() (plugin.ConfigPolicy, error) {
+	policy := plugin.NewConfigPolicy()
+
+	for _, item := range configItems {
+		switch def := item.def.(type) {
+		case bool:
+			policy.AddNewBoolRule([]string{""}, item.key, item.required, plugin.SetDefaultBool(def))
+		case int64:
+			policy.AddNewIntRule([]string{""}, item.key, item.required, plugin.SetDefaultInt(def))
+		case float64:
+			policy.AddNewFloatRule([]string{""}, item.key, item.required, plugin.SetDefaultFloat(def))
+		case string:
+			if item.required || item.optional {
+				policy.AddNewStringRule([]string{""}, item.key, item.required)
+			} else {
+				policy.AddNewStringRule([]string{""}, item.key, item.required, plugin.SetDefaultString(def))
 			}
 		}
 	}
+
+	return *policy, nil
 }
 
 // Publish publishes metric data to influxdb
 // currently only 0.9 version of influxdb are supported
 func (ip *InfluxPublisher) Publish(metrics []plugin.Metric, pluginConfig plugin.Config) error {
-	config, err := getConfig(pluginConfig)
+	config, logger, err := parsedConfigs.get(pluginConfig)
 	if err != nil {
 		return err
 	}
 
-	logger := getLogger(config)
+	points := processMetrics(metrics, config, logger)
 
-	con, err := selectClientConnection(config)
+	batches, destinations, err := batchPoints(points, config, logger)
 	if err != nil {
-		logger.Error(err)
 		return err
 	}
+	err = writeBatches(batches, destinations, config, logger)
+
+	if config.dryRun {
+		logger.WithFields(log.Fields{
+			"metrics":      len(metrics),
+			"points":       len(points),
+			"batches":      len(destinations),
+			"destinations": destinations,
+		}).Info("Dry run, nothing was written")
+		return nil
+	}
+	return err
+}
 
-	//Set up batch points
-	bps, _ := client.NewBatchPoints(client.BatchPointsConfig{
-		Database:        config.database,
-		RetentionPolicy: config.retention,
-		Precision:       config.precision,
+// writeBatches writes every batch, even if writing a previous one failed, and returns the first error.
+// Batches are dumped when debug-dump is set and only logged on dry runs.
+func writeBatches(batches map[destination]client.BatchPoints, destinations []destination, config configuration, logger *log.Entry) error {
+	var err error
+	for _, dest := range destinations {
+		if config.debugDump != "" {
+			if derr := dumperFor(config).dump(batches[dest]); derr != nil {
+				logger.WithField("err", derr).Warn("Unable to dump batch points")
+			}
+		}
+		if config.dryRun {
+			dryRun(dest, batches[dest], config.debugDump == "", logger)
+			continue
+		}
+		start := time.Now()
+		werr := writeBatch(config, dest, batches[dest], logger)
+		stats.batch(len(batches[dest].Points()), time.Since(start), werr)
+		if werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// dryRun logs what would be written to a destination, including the line protocol unless it is dumped
+func dryRun(dest destination, bps client.BatchPoints, lines bool, logger *log.Entry) {
+	entry := logger.WithFields(log.Fields{
+		"database":  dest.database,
+		"retention": dest.retention,
+		"points":    len(bps.Points()),
 	})
+	if lines {
+		entry = entry.WithField("line-protocol", string(lineProtocol(bps)))
+	}
+	entry.Info("Dry run, batch not written")
+}
 
-	isMultiFields := config.isMultiFields
-	mpoints := map[string]point{}
-	for _, m := range metrics {
-		ns, tags := replaceDynamicElement(m)
+// processMetrics converts metrics into points, then deduplicates, aggregates and limits the cardinality of points as configured
+func processMetrics(metrics []plugin.Metric, config configuration, logger *log.Entry) []point {
+	points := convertMetrics(metrics, config, logger)
 
-		// Add "unit"" if we do not already have a "unit" tag
-		if _, ok := m.Tags["unit"]; !ok {
-			tags["unit"] = m.Unit
+	if config.dedup != DedupNone {
+		var duplicates int
+		points, duplicates = deduplicatorFor(config).filter(points, time.Now())
+		stats.drop(dropDedup, duplicates)
+		if duplicates > 0 {
+			logger.WithField("duplicates", duplicates).Info("Removed duplicate points")
 		}
+	}
 
-		// Process the tags for this metric
-		for k, v := range m.Tags {
-			// Convert the standard tag describing where the plugin is running to "source"
-			if k == "plugin_running_on" {
-				// Unless the "source" tag is already being used
-				if _, ok := m.Tags["source"]; !ok {
-					k = "source"
-				}
+	if config.aggregateWindow > 0 {
+		points = aggregatorFor(config, logger).add(points, time.Now())
+	}
+
+	if config.maxSeries > 0 {
+		n := len(points)
+		points = guardFor(config).filter(points, time.Now(), logger)
+		stats.drop(dropCardinality, n-len(points))
+	}
+	return points
+}
+
+// batchPoints groups points into one batch per destination, destinations are returned in the order of their first point
+func batchPoints(points []point, config configuration, logger *log.Entry) (map[destination]client.BatchPoints, []destination, error) {
+	//Set up batch points, one per destination
+	batches := map[destination]client.BatchPoints{}
+	destinations := []destination{}
+	for _, p := range points {
+		bps, ok := batches[p.dest]
+		if !ok {
+			var err error
+			bps, err = client.NewBatchPoints(client.BatchPointsConfig{
+				Database:        p.dest.database,
+				RetentionPolicy: p.dest.retention,
+				Precision:       config.precision,
+			})
+			if err != nil {
+				logger.Error(err)
+				return nil, nil, err
 			}
-			tags[k] = v
+			batches[p.dest] = bps
+			destinations = append(destinations, p.dest)
+		}
+
+		pt, err := client.NewPoint(strings.Join(p.ns, "/"), p.tags, p.fields, p.ts)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"err":          err,
+				"batch-points": bps.Points(),
+				"point":        pt,
+			}).Error("Publishing failed. Problem creating data point")
+			return nil, nil, err
 		}
+		bps.AddPoint(pt)
+	}
+	return batches, destinations, nil
+}
+
+// writeBatch writes batch points to their destination using a connection from the pool
+func writeBatch(config configuration, dest destination, bps client.BatchPoints, logger *log.Entry) error {
+	config.database = dest.database
+	config.retention = dest.retention
+
+	con, err := selectClientConnection(config, logger)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+
+	err = con.write(bps)
+	if nerr, ok := err.(net.Error); ok && !nerr.Timeout() {
+		// The connection may have been closed by InfluxDB or a proxy, try once more on a new one
+		logger.WithField("err", err).Warn("Retrying to publish on a new connection")
+		pool.remove(con)
+		stats.retry()
+		if con, err = selectClientConnection(config, logger); err != nil {
+			logger.Error(err)
+			return err
+		}
+		err = con.write(bps)
+	}
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"err":          err,
+			"batch-points": bps,
+		}).Error("publishing failed")
+		pool.remove(con)
+		return err
+	}
+	logger.WithFields(log.Fields{
+		"batch-points": bps.Points(),
+	}).Debug("publishing metrics")
+
+	return nil
+}
+
+// convertMetrics converts metrics into points, grouping common namespaces into
+// multiple fields when isMultiFields is set
+func convertMetrics(metrics []plugin.Metric, config configuration, logger *log.Entry) []point {
+	points := []point{}
+	mpoints := map[string]point{}
+	duplicates := 0
+	for _, m := range metrics {
+		ns, tags := processTags(m, config)
 
 		data := m.Data
 
 		//publishing of nil value causes errors
 		if data == nil {
-			log.Errorf("Received nil value of metric, this metric will not be published, namespace: %s, timestamp: %s", strings.Join(m.Namespace.Strings(), "/"), m.Timestamp.String())
+			stats.drop(dropNil, 1)
+			logger.Errorf("Received nil value of metric, this metric will not be published, namespace: %s, timestamp: %s", strings.Join(m.Namespace.Strings(), "/"), m.Timestamp.String())
 			continue
 		}
 
+		dest, err := routeMetric(m.Namespace.Strings(), tags, config)
+		if err != nil {
+			stats.drop(dropRoute, 1)
+			logger.Errorf("Unable to select the database of metric, this metric will not be published, namespace: %s, error: %s", strings.Join(m.Namespace.Strings(), "/"), err)
+			continue
+		}
+		extra := metadataFields(m, config)
+
+		// Derive the per second rate of counters from their previous value
+		if isCounter(m.Namespace.Strings(), config.counters) {
+			key := destinationKey(config) + separator + point{ns: ns, tags: tags}.seriesKey()
+			if rate, ok := counters.rate(key, data, m.Timestamp); ok {
+				extra["rate"] = rate
+			}
+		}
+
 		// NOTE: uint64 is specifically not supported by influxdb client due to potential overflow
 		//without convertion of uint64 to int64, data with uint64 type will be saved as strings in influx database
 		v, ok := m.Data.(uint64)
 		if ok {
 			data = int64(v)
 			if v > maxInt64 {
-				log.Errorf("Overflow during conversion uint64 to int64, value after conversion to int64: %d, desired uint64 value: %d ", data, v)
+				stats.overflow()
+				logger.Errorf("Overflow during conversion uint64 to int64, value after conversion to int64: %d, desired uint64 value: %d ", data, v)
 			}
 
 			m.Data = data
 		}
 
-		if !isMultiFields {
-			pt, err := client.NewPoint(strings.Join(ns, "/"), tags, map[string]interface{}{
-				"value": data,
-			}, m.Timestamp)
-			if err != nil {
-				logger.WithFields(log.Fields{
-					"err":          err,
-					"batch-points": bps.Points(),
-					"point":        pt,
-				}).Error("Publishing failed. Problem creating data point")
-				return err
+		if !config.isMultiFields {
+			fields := map[string]interface{}{"value": data}
+			for k, v := range extra {
+				fields[k] = v
 			}
-			bps.AddPoint(pt)
-		} else {
-			groupCommonNamespaces(m, tags, mpoints)
+			points = append(points, point{ns: ns, tags: tags, ts: m.Timestamp, fields: fields, dest: dest})
+		} else if groupCommonNamespaces(m, tags, extra, dest, config.dedup, mpoints) {
+			duplicates++
 		}
 	}
 
-	if isMultiFields {
-		for _, p := range mpoints {
-			pt, err := client.NewPoint(strings.Join(p.ns, "/"), p.tags, p.fields, p.ts)
-			if err != nil {
-				logger.WithFields(log.Fields{
-					"err":          err,
-					"batch-points": bps.Points(),
-					"point":        pt,
-				}).Error("Publishing failed. Problem creating data point")
-				return err
-			}
-			bps.AddPoint(pt)
-		}
+	if duplicates > 0 {
+		stats.drop(dropDedup, duplicates)
+		logger.WithField("duplicates", duplicates).Info("Removed duplicate fields")
 	}
-
-	err = con.write(bps)
-	if err != nil {
-		logger.WithFields(log.Fields{
-			"err":          err,
-			"batch-points": bps,
-		}).Error("publishing failed")
-		// Remove connction from pool since something is wrong
-		m.Lock()
-		con.closeClientConnection()
-		delete(connPool, con.Key)
-		m.Unlock()
-		return err
+	for _, p := range mpoints {
+		points = append(points, p)
 	}
-	logger.WithFields(log.Fields{
-		"batch-points": bps.Points(),
-	}).Debug("publishing metrics")
-
-	return nil
+	return points
 }
 
+// getLogger returns a logger of its own for a configuration, writing to the output of the
+// standard logger, so that tasks with different log levels don't change each other's level
 func getLogger(config configuration) *log.Entry {
-	logger := log.WithFields(log.Fields{
+	std := log.StandardLogger()
+	l := log.New()
+	l.Out = std.Out
+	l.Formatter = std.Formatter
+	l.Hooks = std.Hooks
+
+	logger := l.WithFields(log.Fields{
 		"plugin-name":    Name,
 		"plugin-version": Version,
 		"plugin-type":    PluginType,
+		"task":           config.task,
+		"database":       config.database,
+		"endpoint":       fmt.Sprintf("%s://%s:%d", config.scheme, config.host, config.port),
 	})
 
 	// default
-	log.SetLevel(log.WarnLevel)
+	l.Level = log.WarnLevel
 
 	levelValue := config.logLevel
 	if levelValue != "undefined" {
 		if level, err := log.ParseLevel(strings.ToLower(levelValue)); err == nil {
-			log.SetLevel(level)
+			l.Level = level
 		} else {
-			log.WithFields(log.Fields{
+			logger.WithFields(log.Fields{
 				"value":             strings.ToLower(levelValue),
 				"acceptable values": "warn, error, debug, info",
 			}).Warn("Invalid log-level config value")
@@ -321,79 +826,152 @@ type clientConnection struct {
 	Key      string
 	Conn     *client.Client
 	LastUsed time.Time
+
+	// Endpoint and credentials used for queries
+	url        *url.URL
+	auth       credentials
+	skipVerify bool
+	http       *http.Client
+	// How long the connection can sit around unused
+	idle time.Duration
 }
 
 // Create database if it doesn't exist
-// workaround: use http instead of client library because of the issue
-// ref: https://github.com/influxdata/influxdb/issues/8108
-func (c *clientConnection) initDB(u *url.URL, user, pass, db string, verify bool) error {
-	urlStr := fmt.Sprintf("%s/query", u.String())
+func (c *clientConnection) initDB(db string) error {
+	_, err := c.query("POST", fmt.Sprintf("CREATE DATABASE %s", quoteIdent(db)))
+	return err
+}
 
-	req, err := http.NewRequest("POST", urlStr, nil)
+// Check if database exists
+func (c *clientConnection) dbExists(db string) (bool, error) {
+	resp, err := c.query("GET", "SHOW DATABASES")
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	query := fmt.Sprintf("CREATE DATABASE %s", db)
-	params := req.URL.Query()
-	params.Set("q", query)
-	req.URL.RawQuery = params.Encode()
+	for _, r := range resp.Results {
+		for _, s := range r.Series {
+			for _, v := range s.Values {
+				if len(v) > 0 && v[0] == db {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
 
-	req.SetBasicAuth(user, pass)
+// Prepare the database of the connection: create it if it doesn't exist and allowed to,
+// then manage its retention policy and continuous queries when configured
+func (c *clientConnection) bootstrap(config configuration, logger *log.Entry) error {
+	db := config.database
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: verify},
+	if config.createDatabase != CreateDatabaseNever {
+		exists, err := c.dbExists(db)
+		if err != nil {
+			return err
+		}
+		switch {
+		case exists:
+		case config.createDatabase == CreateDatabaseVerifyOnly:
+			return fmt.Errorf("database %s does not exist on %s, create it or set create-database to %s", db, c.url.Host, CreateDatabase)
+		default:
+			logger.WithField("database", db).Info("Creating database")
+			if err := c.initDB(db); err != nil {
+				return err
+			}
+		}
 	}
-	client := &http.Client{Transport: tr}
 
-	_, err = client.Do(req)
-	if err != nil {
-		return err
+	if config.retentionPolicy.duration != "" {
+		if err := c.ensureRetentionPolicy(db, config.retentionPolicy, logger); err != nil {
+			return err
+		}
 	}
 
+	if config.provisioning != nil {
+		if err := c.provision(db, *config.provisioning, logger); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Check if database exists
+// queryResponse is the JSON response of the query endpoint
+type queryResponse struct {
+	Results []queryResult `json:"results"`
+	Error   string        `json:"error"`
+}
+
+type queryResult struct {
+	Series []querySeries `json:"series"`
+	Error  string        `json:"error"`
+}
+
+type querySeries struct {
+	Name    string          `json:"name"`
+	Columns []string        `json:"columns"`
+	Values  [][]interface{} `json:"values"`
+}
+
+// Run an InfluxQL query, returning an error for HTTP error statuses and failed statements
 // workaround: use http instead of client library because of the issue
 // ref: https://github.com/influxdata/influxdb/issues/8108
-func (c *clientConnection) dbExists(u *url.URL, user, pass, db string, verify bool) bool {
-	urlStr := fmt.Sprintf("%s/query", u.String())
+func (c *clientConnection) query(method, query string) (queryResponse, error) {
+	result := queryResponse{}
+	urlStr := fmt.Sprintf("%s/query", c.url.String())
 
-	req, err := http.NewRequest("GET", urlStr, nil)
+	req, err := http.NewRequest(method, urlStr, nil)
 	if err != nil {
-		return false
+		return result, err
 	}
 
-	query := fmt.Sprintf("SHOW DATABASES")
 	params := req.URL.Query()
 	params.Set("q", query)
 	req.URL.RawQuery = params.Encode()
 
-	req.SetBasicAuth(user, pass)
+	c.auth.authorize(req)
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: verify},
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return result, err
 	}
-	client := &http.Client{Transport: tr}
+	defer resp.Body.Close()
 
-	resp, err := client.Do(req)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return false
+		return result, err
 	}
+	// The body is only JSON for successful requests and some of the errors
+	json.Unmarshal(body, &result)
 
-	if resp.Body != nil {
-		bodyText, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return false
+	if result.Error != "" {
+		return result, fmt.Errorf("query %q failed with status %d: %s", query, resp.StatusCode, result.Error)
+	}
+	if resp.StatusCode/100 != 2 {
+		return result, fmt.Errorf("query %q failed with status %d: %s", query, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	for _, r := range result.Results {
+		if r.Error != "" {
+			return result, fmt.Errorf("query %q failed: %s", query, r.Error)
 		}
+	}
+	return result, nil
+}
 
-		if strings.Contains(string(bodyText), db) {
-			return true
-		}
-		resp.Body.Close()
+// httpClient returns the HTTP client of queries, connections of the pool share theirs
+func (c *clientConnection) httpClient() *http.Client {
+	if c.http != nil {
+		return c.http
 	}
-	return false
+	return newHTTPClient(c.skipVerify)
+}
+
+func newHTTPClient(skipVerify bool) *http.Client {
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: skipVerify},
+	}
+	return &http.Client{Transport: tr, Timeout: httpTimeout}
 }
 
 // Map the batch points write into client.Client
@@ -406,79 +984,134 @@ func (c *clientConnection) closeClientConnection() error {
 	return (*c.Conn).Close()
 }
 
-func selectClientConnection(config configuration) (*clientConnection, error) {
-	// This is not an ideal way to get the logger but deferring solving this for a later date
-	logger := getLogger(config)
+// idleTimeout returns how long the connection can sit around unused
+func (c *clientConnection) idleTimeout() time.Duration {
+	if c.idle <= 0 {
+		return defaultConnectionIdle
+	}
+	return c.idle
+}
 
+func selectClientConnection(config configuration, logger *log.Entry) (*clientConnection, error) {
 	scheme := config.scheme
 
-	u, err := url.Parse(fmt.Sprintf("%s://%s:%d", scheme, config.host, config.port))
+	u, err := endpointURL(config)
 	if err != nil {
 		logger.Error("Error parsing URL")
 		return nil, err
 	}
 
-	// Pool changes need to be safe (read & write) since the plugin can be called concurrently by snapteld.
-	m.Lock()
-	defer m.Unlock()
-
-	user := config.user
-	pass := config.password
+	auth := config.credentials()
+	user := auth.user
 	db := config.database
-	key := connectionKey(u, user, db)
-
-	// Do we have a existing client?
-	if connPool[key] == nil {
-		// create one and add to the pool
-		var con client.Client
-		var err error
-		if scheme != UDP {
-			con, err = client.NewHTTPClient(client.HTTPConfig{
-				Addr:               u.String(),
-				Username:           user,
-				Password:           pass,
-				InsecureSkipVerify: config.skipVerify,
-			})
-		} else {
-			con, err = client.NewUDPClient(client.UDPConfig{
-				Addr: u.Host,
-			})
-		}
+	key := connectionKey(config, u, auth)
 
+	open := func() (*clientConnection, error) {
+		con, err := newClient(u, auth, config.skipVerify)
 		if err != nil {
 			return nil, err
 		}
 
-		cCon := &clientConnection{
-			Key:      key,
-			Conn:     &con,
-			LastUsed: time.Now(),
-		}
-		if !initialized && scheme != UDP {
-			if cCon.dbExists(u, user, pass, db, config.skipVerify) {
-				initialized = true
-			} else {
-				err = cCon.initDB(u, user, pass, db, config.skipVerify)
-				if err != nil {
-					return nil, err
-				}
+		logger.Debug("Opening new InfluxDB connection[", user, "@", db, " ", u.String(), "]")
+		return &clientConnection{
+			Conn:       &con,
+			url:        u,
+			auth:       auth,
+			skipVerify: config.skipVerify,
+			http:       newHTTPClient(config.skipVerify),
+			idle:       config.connectionIdleTimeout,
+		}, nil
+	}
+	if scheme == UDP {
+		return pool.get(key, "", open, nil)
+	}
+	return pool.get(key, bootstrapKey(config), open, func(c *clientConnection) error {
+		return c.bootstrap(config, logger)
+	})
+}
+
+// endpointURL returns the URL of the InfluxDB endpoint
+func endpointURL(config configuration) (*url.URL, error) {
+	return url.Parse(fmt.Sprintf("%s://%s:%d", config.scheme, config.host, config.port))
+}
+
+// newClient creates a client of an endpoint, authenticated over HTTP with a token, or a user and password
+func newClient(u *url.URL, auth credentials, skipVerify bool) (client.Client, error) {
+	if u.Scheme == UDP {
+		return client.NewUDPClient(client.UDPConfig{
+			Addr: u.Host,
+		})
+	}
+	if auth.token != "" {
+		return newTokenClient(u, auth.token, skipVerify), nil
+	}
+	return client.NewHTTPClient(client.HTTPConfig{
+		Addr:               u.String(),
+		Username:           auth.user,
+		Password:           auth.password,
+		Timeout:            httpTimeout,
+		InsecureSkipVerify: skipVerify,
+	})
+}
+
+// connectionKey identifies a connection by every setting the client depends on, so that tasks only share
+// a connection built with their own settings and that rotated credentials or changed settings are used
+func connectionKey(config configuration, u *url.URL, auth credentials) string {
+	return fmt.Sprintf("%s:%s:%s:%s:auth=%s:skip-verify=%t:idle=%s",
+		u.String(), auth.user, auth.fingerprint(), config.database, config.auth, config.skipVerify, config.connectionIdleTimeout)
+}
+
+// bootstrapKey identifies how a database is bootstrapped, so that tasks writing to the same database
+// with different provisioning settings each bootstrap it
+func bootstrapKey(config configuration) string {
+	p := provisioning{}
+	if config.provisioning != nil {
+		p = *config.provisioning
+	}
+	settings := fmt.Sprintf("%s/%+v/%+v", config.createDatabase, config.retentionPolicy, p)
+	sum := sha256.Sum256([]byte(settings))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// processTags returns the namespace of a metric, stripped of its dynamic elements,
+// along with all the tags that should be attached to the point
+func processTags(m plugin.Metric, config configuration) ([]string, map[string]string) {
+	ns, tags := replaceDynamicElement(m)
+
+	// Add "unit" if we do not already have a "unit" tag
+	if _, ok := m.Tags["unit"]; !ok && config.unitMode == MetaTag {
+		tags["unit"] = m.Unit
+	}
+
+	// Add "description" if we do not already have a "description" tag
+	if _, ok := m.Tags["description"]; !ok && config.descriptionMode == MetaTag && m.Description != "" {
+		tags["description"] = m.Description
+	}
+
+	// Process the tags for this metric
+	for k, v := range m.Tags {
+		// Convert the standard tag describing where the plugin is running to "source"
+		if k == "plugin_running_on" {
+			// Unless the "source" tag is already being used
+			if _, ok := m.Tags["source"]; !ok {
+				k = "source"
 			}
 		}
-		// Add to the pool
-		connPool[key] = cCon
-
-		logger.Debug("Opening new InfluxDB connection[", user, "@", db, " ", u.String(), "]")
-		return connPool[key], nil
+		tags[k] = v
 	}
-	// Update when it was accessed
-	connPool[key].LastUsed = time.Now()
-	// Return it
-	logger.Debug("Using open InfluxDB connection[", user, "@", db, " ", u.String(), "]")
-	return connPool[key], nil
+	return ns, tags
 }
 
-func connectionKey(u *url.URL, user, db string) string {
-	return fmt.Sprintf("%s:%s:%s", u.String(), user, db)
+// metadataFields returns the unit and description of a metric which should be stored as fields
+func metadataFields(m plugin.Metric, config configuration) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if _, ok := m.Tags["unit"]; !ok && config.unitMode == MetaField && m.Unit != "" {
+		fields["unit"] = m.Unit
+	}
+	if _, ok := m.Tags["description"]; !ok && config.descriptionMode == MetaField && m.Description != "" {
+		fields["description"] = m.Description
+	}
+	return fields
 }
 
 func replaceDynamicElement(m plugin.Metric) ([]string, map[string]string) {
@@ -503,36 +1136,48 @@ func replaceDynamicElement(m plugin.Metric) ([]string, map[string]string) {
 }
 
 // groupCommonNamespaces groups common namespaces, those that differ at the leaf, into one data point with multiple influx fields.
-func groupCommonNamespaces(m plugin.Metric, tags map[string]string, mpoints map[string]point) {
+// Extra fields (metadata, rate) are stored next to the leaf field, prefixed with its name (e.g. "load1_unit").
+// When deduplicating, only samples with the same timestamp are grouped and a sample whose leaf is already part of the point
+// is a duplicate which replaces the field unless the first one is kept. It returns whether the sample was removed as a duplicate.
+func groupCommonNamespaces(m plugin.Metric, tags map[string]string, extra map[string]interface{}, dest destination, keep string, mpoints map[string]point) bool {
 	// Slices to the second to last
 	elems, tag := replaceDynamicElement(m)
 	s2l := elems[:len(elems)-1]
 	if len(s2l) == 0 {
 		s2l = elems
 	}
-
-	// Appends tag keys
-	mkeys := []string{}
 	for k, v := range tags {
 		tag[k] = v
-		mkeys = append(mkeys, k, v)
 	}
-	// Appends namespace prefix
-	mkeys = append(mkeys, s2l...)
 
-	// Converts the map keys to a string key
-	sk := strings.Join(mkeys, separator)
+	// Groups by the series of the namespace prefix and tags, and the destination
+	sk := strings.Join([]string{point{ns: s2l, tags: tag}.seriesKey(), dest.database, dest.retention}, separator)
+	dedup := keep == DedupFirst || keep == DedupLast
+	if dedup {
+		sk += separator + strconv.FormatInt(m.Timestamp.UnixNano(), 10)
+	}
 
-	// Groups fields by the namespace common prefix and tags
+	// Adds the leaf as a field of the point of the group
 	fieldName := elems[len(elems)-1]
-	if p, ok := mpoints[sk]; !ok {
-		mpoints[sk] = point{
+	p, ok := mpoints[sk]
+	if !ok {
+		p = point{
 			ns:     s2l,
 			tags:   tag,
 			ts:     m.Timestamp,
-			fields: map[string]interface{}{fieldName: m.Data},
+			fields: map[string]interface{}{},
+			dest:   dest,
 		}
-	} else {
-		p.fields[fieldName] = m.Data
+		mpoints[sk] = p
+	}
+	_, duplicate := p.fields[fieldName]
+	duplicate = duplicate && dedup
+	if duplicate && keep == DedupFirst {
+		return true
+	}
+	p.fields[fieldName] = m.Data
+	for k, v := range extra {
+		p.fields[fieldName+"_"+k] = v
 	}
+	return duplicate
 }
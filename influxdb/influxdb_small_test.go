@@ -21,8 +21,13 @@ limitations under the License.
 package influxdb
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
+	log "github.com/Sirupsen/logrus"
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
@@ -53,3 +58,303 @@ func TestInfluxDBPlugin(t *testing.T) {
 		})
 	})
 }
+
+func TestMetadataModes(t *testing.T) {
+	Convey("Given a metric with unit and description", t, func() {
+		m := plugin.Metric{
+			Namespace:   plugin.NewNamespace("intel", "psutil", "load", "load1"),
+			Timestamp:   time.Now(),
+			Tags:        map[string]string{"plugin_running_on": "host1"},
+			Unit:        "Load/1M",
+			Description: "load average over 1 minute",
+			Data:        1.5,
+		}
+
+		Convey("unit is a tag by default and description is dropped", func() {
+			config := configuration{unitMode: MetaTag, descriptionMode: MetaNone}
+			ns, tags := processTags(m, config)
+			So(ns, ShouldResemble, []string{"intel", "psutil", "load", "load1"})
+			So(tags, ShouldResemble, map[string]string{"unit": "Load/1M", "source": "host1"})
+			So(metadataFields(m, config), ShouldBeEmpty)
+		})
+
+		Convey("unit and description can be stored as fields", func() {
+			config := configuration{unitMode: MetaField, descriptionMode: MetaField}
+			_, tags := processTags(m, config)
+			So(tags, ShouldNotContainKey, "unit")
+			So(tags, ShouldNotContainKey, "description")
+			So(metadataFields(m, config), ShouldResemble, map[string]interface{}{
+				"unit":        "Load/1M",
+				"description": "load average over 1 minute",
+			})
+		})
+
+		Convey("unit can be dropped entirely", func() {
+			config := configuration{unitMode: MetaNone, descriptionMode: MetaNone}
+			_, tags := processTags(m, config)
+			So(tags, ShouldNotContainKey, "unit")
+			So(metadataFields(m, config), ShouldBeEmpty)
+		})
+
+		Convey("metadata fields are prefixed with the leaf when grouping", func() {
+			config := configuration{unitMode: MetaField, descriptionMode: MetaNone}
+			mpoints := map[string]point{}
+			_, tags := processTags(m, config)
+			groupCommonNamespaces(m, tags, metadataFields(m, config), destination{}, DedupNone, mpoints)
+			So(mpoints, ShouldHaveLength, 1)
+			for _, p := range mpoints {
+				So(p.fields, ShouldResemble, map[string]interface{}{"load1": 1.5, "load1_unit": "Load/1M"})
+			}
+		})
+	})
+
+	Convey("Invalid metadata modes are rejected", t, func() {
+		_, err := getMetaMode(plugin.Config{"unit-mode": "label"}, "unit-mode", MetaTag)
+		So(err, ShouldNotBeNil)
+		mode, err := getMetaMode(plugin.Config{}, "unit-mode", MetaTag)
+		So(err, ShouldBeNil)
+		So(mode, ShouldEqual, MetaTag)
+	})
+}
+
+func TestBootstrap(t *testing.T) {
+	Convey("Given an InfluxDB server", t, func() {
+		queries := []string{}
+		status := http.StatusOK
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query().Get("q")
+			queries = append(queries, q)
+			w.WriteHeader(status)
+			switch {
+			case status == http.StatusUnauthorized:
+				w.Write([]byte(`{"error":"authorization failed"}`))
+			case q == "SHOW DATABASES":
+				w.Write([]byte(`{"results":[{"statement_id":0,"series":[{"name":"databases","columns":["name"],"values":[["_internal"],["test"]]}]}]}`))
+			default:
+				w.Write([]byte(`{"results":[{"statement_id":0}]}`))
+			}
+		}))
+		defer ts.Close()
+		u, _ := url.Parse(ts.URL)
+		c := &clientConnection{url: u}
+		logger := log.WithField("test", "bootstrap")
+
+		Convey("existing databases are found by name", func() {
+			exists, err := c.dbExists("test")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+
+			exists, err = c.dbExists("tes")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+		})
+
+		Convey("a missing database is created", func() {
+			So(c.bootstrap(configuration{database: "other", createDatabase: CreateDatabase}, logger), ShouldBeNil)
+			So(queries, ShouldResemble, []string{"SHOW DATABASES", `CREATE DATABASE "other"`})
+		})
+
+		Convey("an existing database is not created", func() {
+			So(c.bootstrap(configuration{database: "test", createDatabase: CreateDatabase}, logger), ShouldBeNil)
+			So(queries, ShouldResemble, []string{"SHOW DATABASES"})
+		})
+
+		Convey("a missing database is an error when only verifying", func() {
+			err := c.bootstrap(configuration{database: "other", createDatabase: CreateDatabaseVerifyOnly}, logger)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "does not exist")
+			So(queries, ShouldResemble, []string{"SHOW DATABASES"})
+		})
+
+		Convey("the database is not checked when creation is disabled", func() {
+			So(c.bootstrap(configuration{database: "other", createDatabase: CreateDatabaseNever}, logger), ShouldBeNil)
+			So(queries, ShouldBeEmpty)
+		})
+
+		Convey("HTTP errors are reported", func() {
+			status = http.StatusUnauthorized
+			err := c.initDB("test")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "authorization failed")
+
+			_, err = c.dbExists("test")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAuth(t *testing.T) {
+	Convey("Given a configuration", t, func() {
+		config := plugin.Config{
+			"host":          "localhost",
+			"port":          int64(8086),
+			"database":      "test",
+			"retention":     "autogen",
+			"scheme":        HTTP,
+			"skip-verify":   false,
+			"isMultiFields": false,
+		}
+
+		Convey("authentication is disabled without credentials", func() {
+			cfg, err := getConfig(config)
+			So(err, ShouldBeNil)
+			So(cfg.auth, ShouldEqual, AuthNone)
+			So(cfg.credentials(), ShouldResemble, credentials{})
+		})
+
+		Convey("basic authentication is selected with a user", func() {
+			config["user"] = "admin"
+			config["password"] = "admin"
+			cfg, err := getConfig(config)
+			So(err, ShouldBeNil)
+			So(cfg.auth, ShouldEqual, AuthBasic)
+			So(cfg.credentials(), ShouldResemble, credentials{user: "admin", password: "admin"})
+		})
+
+		Convey("token authentication is selected with a token", func() {
+			config["user"] = "admin"
+			config["token"] = "abc"
+			cfg, err := getConfig(config)
+			So(err, ShouldBeNil)
+			So(cfg.auth, ShouldEqual, AuthToken)
+			So(cfg.credentials(), ShouldResemble, credentials{token: "abc"})
+		})
+
+		Convey("explicit modes require their credentials", func() {
+			config["auth"] = AuthBasic
+			config["user"] = "admin"
+			_, err := getConfig(config)
+			So(err, ShouldNotBeNil)
+
+			config["auth"] = AuthToken
+			_, err = getConfig(config)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("credentials are ignored over UDP", func() {
+			config["user"] = "admin"
+			config["scheme"] = UDP
+			config["port"] = int64(4444)
+			cfg, err := getConfig(config)
+			So(err, ShouldBeNil)
+			So(cfg.auth, ShouldEqual, AuthNone)
+
+			config["auth"] = AuthToken
+			_, err = getConfig(config)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("connections are not shared across settings of the client", func() {
+			config["user"] = "admin"
+			config["password"] = "admin"
+			key := func() string {
+				cfg, err := getConfig(config)
+				So(err, ShouldBeNil)
+				u, err := endpointURL(cfg)
+				So(err, ShouldBeNil)
+				return connectionKey(cfg, u, cfg.credentials())
+			}
+			keys := map[string]bool{key(): true}
+			for _, change := range []struct {
+				key   string
+				value interface{}
+			}{
+				{"password", "secret"},
+				{"token", "abc"},
+				{"skip-verify", true},
+				{"scheme", HTTPS},
+				{"connection-idle-timeout", "5m"},
+				{"database", "other"},
+			} {
+				config[change.key] = change.value
+				k := key()
+				So(keys, ShouldNotContainKey, k)
+				So(k, ShouldNotContainSubstring, "secret")
+				keys[k] = true
+			}
+			So(key(), ShouldEqual, key())
+		})
+
+		Convey("databases are bootstrapped again with other provisioning settings", func() {
+			key := func() string {
+				cfg, err := getConfig(config)
+				So(err, ShouldBeNil)
+				return bootstrapKey(cfg)
+			}
+			keys := map[string]bool{key(): true}
+			for _, change := range []struct {
+				key   string
+				value interface{}
+			}{
+				{"create-database", CreateDatabaseVerifyOnly},
+				{"retention-duration", "7d"},
+				{"retention-replication", int64(2)},
+				{"retention", "week"},
+			} {
+				config[change.key] = change.value
+				k := key()
+				So(keys, ShouldNotContainKey, k)
+				keys[k] = true
+			}
+			So(key(), ShouldEqual, key())
+		})
+	})
+}
+
+func TestLogger(t *testing.T) {
+	Convey("Given configurations with different log levels", t, func() {
+		level := log.GetLevel()
+		debug := getLogger(configuration{logLevel: "debug", task: "a", database: "test", scheme: HTTP, host: "localhost", port: 8086})
+		errors := getLogger(configuration{logLevel: "error", task: "b", database: "test", scheme: HTTP, host: "localhost", port: 8086})
+
+		Convey("each logger has its own level", func() {
+			So(debug.Logger.Level, ShouldEqual, log.DebugLevel)
+			So(errors.Logger.Level, ShouldEqual, log.ErrorLevel)
+			So(log.GetLevel(), ShouldEqual, level)
+		})
+
+		Convey("loggers identify the task, database and endpoint", func() {
+			So(debug.Data["task"], ShouldEqual, "a")
+			So(debug.Data["database"], ShouldEqual, "test")
+			So(debug.Data["endpoint"], ShouldEqual, "http://localhost:8086")
+		})
+
+		Convey("the level defaults to warn", func() {
+			So(getLogger(configuration{logLevel: "undefined"}).Logger.Level, ShouldEqual, log.WarnLevel)
+		})
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	Convey("Given a dry run configuration of an unreachable InfluxDB", t, func() {
+		config := plugin.Config{
+			"host":          "localhost",
+			"port":          int64(1),
+			"database":      "test",
+			"retention":     "autogen",
+			"scheme":        HTTP,
+			"skip-verify":   false,
+			"isMultiFields": false,
+			"dry-run":       true,
+		}
+		metrics := []plugin.Metric{
+			{Namespace: plugin.NewNamespace("intel", "cpu", "idle"), Timestamp: time.Now(), Data: 1.5},
+		}
+
+		Convey("metrics are converted without opening a connection", func() {
+			ip := NewInfluxPublisher()
+			So(ip.Publish(metrics, config), ShouldBeNil)
+			for _, key := range pool.keys() {
+				So(key, ShouldNotContainSubstring, "localhost:1")
+			}
+		})
+
+		Convey("dry runs don't share the state of actual writes", func() {
+			cfg, err := getConfig(config)
+			So(err, ShouldBeNil)
+			actual := cfg
+			actual.dryRun = false
+			So(destinationKey(cfg), ShouldNotEqual, destinationKey(actual))
+		})
+	})
+}
@@ -0,0 +1,268 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// How long a connection can sit around unused by default
+	defaultConnectionIdle = 30 * time.Minute
+	// How long the pool waits at most between checks of idle connections
+	maxIdleCheckWait = 15 * time.Minute
+	// How long requests to InfluxDB can take, so that an unreachable endpoint doesn't stall publishing
+	httpTimeout = 30 * time.Second
+)
+
+// Our connection pool, its watcher starts with the first connection
+var pool = newConnectionPool(realClock{})
+
+// clock tells the time, tests replace it to control the expiry of connections
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// connectionPool keeps the connections to InfluxDB by key and closes those idle for longer than their idle timeout.
+// It is safe for concurrent use since the plugin can be called concurrently by snapteld.
+type connectionPool struct {
+	clock clock
+	conns map[string]*clientConnection
+	// Connections being opened and databases being bootstrapped, callers of the same key wait for them
+	opening       map[string]*pendingOperation
+	bootstrapping map[string]*pendingOperation
+	// Bootstrap settings the database of a connection key has been bootstrapped with, kept when idle
+	// connections are closed but cleared when a connection is removed after a failure
+	bootstrapped map[string]map[string]bool
+	// Closed to stop the watcher, nil when it is not running
+	done  chan struct{}
+	wg    sync.WaitGroup
+	mutex sync.Mutex
+}
+
+// pendingOperation is the outcome of opening a connection or bootstrapping its database, known once done is closed
+type pendingOperation struct {
+	done chan struct{}
+	conn *clientConnection
+	err  error
+}
+
+func newConnectionPool(clk clock) *connectionPool {
+	return &connectionPool{
+		clock:         clk,
+		conns:         make(map[string]*clientConnection),
+		opening:       make(map[string]*pendingOperation),
+		bootstrapping: make(map[string]*pendingOperation),
+		bootstrapped:  make(map[string]map[string]bool),
+	}
+}
+
+// get returns the connection of key, opening it when there is none, once its database has been bootstrapped
+// with settings, which identify how bootstrap provisions the database. bootstrap may be nil when there is nothing to do.
+// Connections are opened and bootstrapped without holding the lock of the pool, since bootstrapping queries InfluxDB,
+// so that only the callers of the same key wait for them.
+func (p *connectionPool) get(key, settings string, open func() (*clientConnection, error), bootstrap func(*clientConnection) error) (*clientConnection, error) {
+	c, err := p.connection(key, open)
+	if err != nil || bootstrap == nil {
+		return c, err
+	}
+	if err := p.bootstrap(c, settings, bootstrap); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connection returns the connection of key, opening it when there is none
+func (p *connectionPool) connection(key string, open func() (*clientConnection, error)) (*clientConnection, error) {
+	p.mutex.Lock()
+	if c := p.conns[key]; c != nil {
+		c.LastUsed = p.clock.Now()
+		p.mutex.Unlock()
+		return c, nil
+	}
+	if o := p.opening[key]; o != nil {
+		p.mutex.Unlock()
+		<-o.done
+		return o.conn, o.err
+	}
+	o := &pendingOperation{done: make(chan struct{})}
+	p.opening[key] = o
+	p.mutex.Unlock()
+
+	c, err := open()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.opening, key)
+	// The connection is set up before the callers waiting for it are released
+	if err == nil {
+		c.Key = key
+		c.LastUsed = p.clock.Now()
+		p.conns[key] = c
+	}
+	o.conn, o.err = c, err
+	close(o.done)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.done == nil {
+		p.done = make(chan struct{})
+		p.wg.Add(1)
+		go p.watch(p.done)
+	}
+	return c, nil
+}
+
+// bootstrap bootstraps the database of a connection unless it was already bootstrapped with settings
+func (p *connectionPool) bootstrap(c *clientConnection, settings string, bootstrap func(*clientConnection) error) error {
+	key := c.Key + separator + settings
+
+	p.mutex.Lock()
+	if p.bootstrapped[c.Key][settings] {
+		p.mutex.Unlock()
+		return nil
+	}
+	if o := p.bootstrapping[key]; o != nil {
+		p.mutex.Unlock()
+		<-o.done
+		return o.err
+	}
+	o := &pendingOperation{done: make(chan struct{})}
+	p.bootstrapping[key] = o
+	p.mutex.Unlock()
+
+	err := bootstrap(c)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.bootstrapping, key)
+	o.err = err
+	close(o.done)
+	if err != nil {
+		return err
+	}
+	if p.bootstrapped[c.Key] == nil {
+		p.bootstrapped[c.Key] = make(map[string]bool)
+	}
+	p.bootstrapped[c.Key][settings] = true
+	return nil
+}
+
+// remove closes a connection and removes it from the pool since something is wrong,
+// its database will be bootstrapped again
+func (p *connectionPool) remove(c *clientConnection) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// The connection may have been replaced already
+	if p.conns[c.Key] == c {
+		delete(p.conns, c.Key)
+	}
+	delete(p.bootstrapped, c.Key)
+	c.closeClientConnection()
+}
+
+// closeIdle closes the connections idle for longer than their idle timeout and
+// returns how long to wait until the next connection may expire
+func (p *connectionPool) closeIdle() time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.clock.Now()
+	wait := maxIdleCheckWait
+	for k, c := range p.conns {
+		left := c.idleTimeout() - now.Sub(c.LastUsed)
+		if left <= 0 {
+			c.closeClientConnection()
+			delete(p.conns, k)
+			continue
+		}
+		if left < wait {
+			wait = left
+		}
+	}
+	return wait
+}
+
+// watch closes idle connections until done is closed
+func (p *connectionPool) watch(done chan struct{}) {
+	defer p.wg.Done()
+
+	wait := p.closeIdle()
+	for {
+		select {
+		case <-p.clock.After(wait):
+			wait = p.closeIdle()
+		case <-done:
+			return
+		}
+	}
+}
+
+// close stops the watcher and closes all the connections, the pool can still be used afterwards
+func (p *connectionPool) close() {
+	p.mutex.Lock()
+	if p.done != nil {
+		close(p.done)
+		p.done = nil
+	}
+	for k, c := range p.conns {
+		c.closeClientConnection()
+		delete(p.conns, k)
+	}
+	p.mutex.Unlock()
+
+	p.wg.Wait()
+}
+
+// state returns the number of connections and the time since the least recently used one was used
+func (p *connectionPool) state() (int, time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.clock.Now()
+	var maxIdle time.Duration
+	for _, c := range p.conns {
+		if idle := now.Sub(c.LastUsed); idle > maxIdle {
+			maxIdle = idle
+		}
+	}
+	return len(p.conns), maxIdle
+}
+
+// keys returns the keys of the connections of the pool
+func (p *connectionPool) keys() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	keys := make([]string, 0, len(p.conns))
+	for k := range p.conns {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,341 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+# Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeClock only moves forward when advanced
+type fakeClock struct {
+	now     time.Time
+	waiters []fakeWaiter
+	mutex   sync.Mutex
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	c := make(chan time.Time, 1)
+	f.waiters = append(f.waiters, fakeWaiter{deadline: f.now.Add(d), c: c})
+	return c
+}
+
+// advance moves the clock forward and fires the waiters whose deadline passed
+func (f *fakeClock) advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = f.now.Add(d)
+	waiters := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.deadline.After(f.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.c <- f.now
+	}
+	f.waiters = waiters
+}
+
+// blockUntil waits for n goroutines to wait on the clock
+func (f *fakeClock) blockUntil(n int) {
+	for {
+		f.mutex.Lock()
+		waiting := len(f.waiters)
+		f.mutex.Unlock()
+		if waiting >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fakeClient records whether it was closed
+type fakeClient struct {
+	closed chan struct{}
+}
+
+func (f *fakeClient) Ping(timeout time.Duration) (time.Duration, string, error) { return 0, "", nil }
+func (f *fakeClient) Write(bp client.BatchPoints) error                         { return nil }
+func (f *fakeClient) Query(q client.Query) (*client.Response, error)            { return &client.Response{}, nil }
+func (f *fakeClient) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func (f *fakeClient) isClosed() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestConnectionPool(t *testing.T) {
+	Convey("Given a connection pool with a fake clock", t, func() {
+		clk := &fakeClock{now: time.Date(2017, 7, 1, 10, 0, 0, 0, time.UTC)}
+		p := newConnectionPool(clk)
+		defer p.close()
+
+		clients := map[string]*fakeClient{}
+		var bootstraps []string
+		bootstrap := func(c *clientConnection) error {
+			bootstraps = append(bootstraps, c.Key)
+			return nil
+		}
+		open := func(key string, idle time.Duration) func() (*clientConnection, error) {
+			return func() (*clientConnection, error) {
+				fc := &fakeClient{closed: make(chan struct{})}
+				clients[key] = fc
+				var c client.Client = fc
+				return &clientConnection{Conn: &c, idle: idle}, nil
+			}
+		}
+		get := func(key string, idle time.Duration) (*clientConnection, error) {
+			return p.get(key, "settings", open(key, idle), bootstrap)
+		}
+
+		Convey("connections are opened once per key", func() {
+			c1, err := get("a", time.Minute)
+			So(err, ShouldBeNil)
+			So(c1.Key, ShouldEqual, "a")
+			c2, err := get("a", time.Minute)
+			So(err, ShouldBeNil)
+			So(c2, ShouldEqual, c1)
+			So(bootstraps, ShouldResemble, []string{"a"})
+
+			n, _ := p.state()
+			So(n, ShouldEqual, 1)
+		})
+
+		Convey("connections that fail to open are not kept", func() {
+			_, err := p.get("a", "", func() (*clientConnection, error) {
+				return nil, errors.New("refused")
+			}, nil)
+			So(err, ShouldNotBeNil)
+			So(p.keys(), ShouldBeEmpty)
+		})
+
+		Convey("idle connections are closed by the watcher", func() {
+			_, err := get("a", time.Minute)
+			So(err, ShouldBeNil)
+			_, err = get("b", time.Hour)
+			So(err, ShouldBeNil)
+
+			clk.blockUntil(1)
+			clk.advance(30 * time.Second)
+			_, maxIdle := p.state()
+			So(maxIdle, ShouldEqual, 30*time.Second)
+
+			clk.blockUntil(1)
+			clk.advance(31 * time.Second)
+			<-clients["a"].closed
+			So(clients["b"].isClosed(), ShouldBeFalse)
+			So(p.keys(), ShouldResemble, []string{"b"})
+
+			Convey("and their database is not bootstrapped again", func() {
+				_, err := get("a", time.Minute)
+				So(err, ShouldBeNil)
+				So(bootstraps, ShouldResemble, []string{"a", "b"})
+			})
+		})
+
+		Convey("using a connection keeps it open", func() {
+			_, err := get("a", time.Minute)
+			So(err, ShouldBeNil)
+			clk.blockUntil(1)
+			clk.advance(50 * time.Second)
+			_, err = get("a", time.Minute)
+			So(err, ShouldBeNil)
+			clk.blockUntil(1)
+			clk.advance(50 * time.Second)
+			clk.blockUntil(1)
+			So(clients["a"].isClosed(), ShouldBeFalse)
+		})
+
+		Convey("removed connections are closed and bootstrapped again", func() {
+			c, err := get("a", time.Minute)
+			So(err, ShouldBeNil)
+			p.remove(c)
+			So(clients["a"].isClosed(), ShouldBeTrue)
+			So(p.keys(), ShouldBeEmpty)
+
+			_, err = get("a", time.Minute)
+			So(err, ShouldBeNil)
+			So(bootstraps, ShouldResemble, []string{"a", "a"})
+		})
+
+		Convey("closing the pool stops the watcher and closes all the connections", func() {
+			_, err := get("a", time.Minute)
+			So(err, ShouldBeNil)
+			p.close()
+			So(clients["a"].isClosed(), ShouldBeTrue)
+			So(p.keys(), ShouldBeEmpty)
+
+			Convey("and the pool can still be used", func() {
+				_, err := get("a", time.Minute)
+				So(err, ShouldBeNil)
+				So(p.keys(), ShouldResemble, []string{"a"})
+			})
+		})
+
+		Convey("databases are bootstrapped again with other settings", func() {
+			c, err := get("a", time.Minute)
+			So(err, ShouldBeNil)
+			c2, err := p.get("a", "other settings", open("a", time.Minute), bootstrap)
+			So(err, ShouldBeNil)
+			So(c2, ShouldEqual, c)
+			_, err = p.get("a", "other settings", open("a", time.Minute), bootstrap)
+			So(err, ShouldBeNil)
+			So(bootstraps, ShouldResemble, []string{"a", "a"})
+
+			Convey("and again when bootstrapping failed", func() {
+				failing := func(*clientConnection) error { return errors.New("database does not exist") }
+				_, err := p.get("a", "verify-only", open("a", time.Minute), failing)
+				So(err, ShouldNotBeNil)
+				_, err = p.get("a", "verify-only", open("a", time.Minute), bootstrap)
+				So(err, ShouldBeNil)
+				So(bootstraps, ShouldResemble, []string{"a", "a", "a"})
+			})
+		})
+
+		Convey("a connection being opened only blocks the callers of its key", func() {
+			release := make(chan struct{})
+			opened := make(chan *clientConnection, 2)
+			opens := 0
+			slow := func() (*clientConnection, error) {
+				opens++
+				<-release
+				var c client.Client = &fakeClient{closed: make(chan struct{})}
+				return &clientConnection{Conn: &c}, nil
+			}
+			for i := 0; i < 2; i++ {
+				go func() {
+					c, _ := p.get("slow", "", slow, nil)
+					opened <- c
+				}()
+			}
+			for {
+				p.mutex.Lock()
+				waiting := p.opening["slow"] != nil
+				p.mutex.Unlock()
+				if waiting {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			_, err := get("a", time.Minute)
+			So(err, ShouldBeNil)
+			n, _ := p.state()
+			So(n, ShouldEqual, 1)
+			So(opened, ShouldBeEmpty)
+
+			close(release)
+			c1, c2 := <-opened, <-opened
+			So(c1, ShouldNotBeNil)
+			So(c2, ShouldEqual, c1)
+			So(opens, ShouldEqual, 1)
+			So(p.keys(), ShouldHaveLength, 2)
+		})
+
+		Convey("callers waiting for a connection being opened bootstrap it once", func() {
+			var mutex sync.Mutex
+			keys := []string{}
+			bootstrapOnce := func(c *clientConnection) error {
+				mutex.Lock()
+				keys = append(keys, c.Key)
+				mutex.Unlock()
+				return nil
+			}
+			for i := 0; i < 20; i++ {
+				key := fmt.Sprintf("concurrent%d", i)
+				release := make(chan struct{})
+				errs := make(chan error, 2)
+				slow := func() (*clientConnection, error) {
+					<-release
+					var c client.Client = &fakeClient{closed: make(chan struct{})}
+					return &clientConnection{Conn: &c}, nil
+				}
+				for j := 0; j < 2; j++ {
+					go func() {
+						_, err := p.get(key, "settings", slow, bootstrapOnce)
+						errs <- err
+					}()
+				}
+				for {
+					p.mutex.Lock()
+					waiting := p.opening[key] != nil
+					p.mutex.Unlock()
+					if waiting {
+						break
+					}
+					time.Sleep(time.Millisecond)
+				}
+				// Lets the other caller wait for the connection being opened
+				time.Sleep(time.Millisecond)
+				close(release)
+				So(<-errs, ShouldBeNil)
+				So(<-errs, ShouldBeNil)
+			}
+			So(keys, ShouldHaveLength, 20)
+			for i, key := range keys {
+				So(key, ShouldStartWith, "concurrent")
+				So(keys[:i], ShouldNotContain, key)
+			}
+		})
+
+		Convey("connections can be used concurrently", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					p.get("a", "settings", func() (*clientConnection, error) {
+						var c client.Client = &fakeClient{closed: make(chan struct{})}
+						return &clientConnection{Conn: &c}, nil
+					}, func(*clientConnection) error { return nil })
+					p.state()
+				}()
+			}
+			wg.Wait()
+			So(p.keys(), ShouldHaveLength, 1)
+		})
+	})
+}
@@ -0,0 +1,138 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Prefix of the names of the metrics exposed to Prometheus
+const prometheusPrefix = "snap_influxdb_publisher_"
+
+var (
+	// Prometheus listeners by address
+	prometheusListeners = make(map[string]net.Listener)
+	// Mutex for synchronizing Prometheus listeners changes
+	prometheusListenersMutex = &sync.Mutex{}
+)
+
+// servePrometheus exposes the statistics of the publisher at http://<addr>/metrics in the Prometheus
+// text format, the listener is started once per address and shared by all tasks
+func servePrometheus(addr string, logger *log.Entry) error {
+	prometheusListenersMutex.Lock()
+	defer prometheusListenersMutex.Unlock()
+
+	if prometheusListeners[addr] != nil {
+		return nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on prometheus-listen %s: %s", addr, err)
+	}
+	prometheusListeners[addr] = l
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writePrometheus(w, stats.snapshot())
+	})
+	go func() {
+		err := http.Serve(l, mux)
+		prometheusListenersMutex.Lock()
+		defer prometheusListenersMutex.Unlock()
+		// Listeners closed by stopPrometheus are already removed
+		if prometheusListeners[addr] == l {
+			logger.WithFields(log.Fields{"address": addr, "err": err}).Error("Prometheus listener stopped")
+			delete(prometheusListeners, addr)
+		}
+	}()
+	logger.WithField("address", l.Addr().String()).Info("Serving Prometheus metrics")
+	return nil
+}
+
+// stopPrometheus closes the Prometheus listeners, they are started again by the next configuration using them
+func stopPrometheus() {
+	prometheusListenersMutex.Lock()
+	defer prometheusListenersMutex.Unlock()
+
+	for addr, l := range prometheusListeners {
+		l.Close()
+		delete(prometheusListeners, addr)
+	}
+}
+
+// writePrometheus writes statistics in the Prometheus text format
+func writePrometheus(w io.Writer, s statsSnapshot) {
+	counter := func(name, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s counter\n%s%s %d\n", prometheusPrefix, name, help, prometheusPrefix, name, prometheusPrefix, name, value)
+	}
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s gauge\n%s%s %s\n", prometheusPrefix, name, help, prometheusPrefix, name, prometheusPrefix, name, formatFloat(value))
+	}
+
+	counter("points_written_total", "Points written to InfluxDB.", s.written)
+	counter("points_failed_total", "Points which failed to be written to InfluxDB.", s.failed)
+
+	name := prometheusPrefix + "points_dropped_total"
+	fmt.Fprintf(w, "# HELP %s Points dropped before being written, by reason.\n# TYPE %s counter\n", name, name)
+	reasons := make([]string, 0, len(s.dropped))
+	for reason := range s.dropped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "%s{reason=%q} %d\n", name, reason, s.dropped[reason])
+	}
+
+	counter("overflows_total", "uint64 values which overflowed when converted to int64.", s.overflows)
+	counter("batches_total", "Batches of points written or which failed to be written.", s.batches)
+	counter("write_errors_total", "Batches which failed to be written.", s.writeErrors)
+	counter("write_retries_total", "Batches written again on a new connection after a failure.", s.retries)
+	writeHistogram(w, "batch_size_points", "Number of points of batches.", s.batchSize)
+	writeHistogram(w, "write_duration_seconds", "Duration of batch writes.", s.writeLatency)
+	gauge("pending_points", "Aggregates waiting for their window to complete.", float64(s.pending))
+	gauge("connections", "Open connections of the pool.", float64(s.connections))
+	gauge("connection_max_idle_seconds", "Time since the least recently used connection of the pool was used.", s.maxIdle.Seconds())
+}
+
+// writeHistogram writes a histogram with cumulative buckets in the Prometheus text format
+func writeHistogram(w io.Writer, name, help string, h histogram) {
+	name = prometheusPrefix + name
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, formatFloat(h.sum), name, h.count)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,127 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPrometheus(t *testing.T) {
+	Convey("Given statistics", t, func() {
+		s := newPublisherStats()
+		s.batch(10, 20*time.Millisecond, nil)
+		s.batch(200, 2*time.Second, nil)
+		s.drop(dropNil, 3)
+
+		Convey("they are written in the Prometheus text format", func() {
+			buf := &bytes.Buffer{}
+			writePrometheus(buf, s.snapshot())
+			out := buf.String()
+			So(out, ShouldContainSubstring, "# TYPE snap_influxdb_publisher_points_written_total counter\nsnap_influxdb_publisher_points_written_total 210\n")
+			So(out, ShouldContainSubstring, `snap_influxdb_publisher_points_dropped_total{reason="nil"} 3`)
+			So(out, ShouldContainSubstring, `snap_influxdb_publisher_batch_size_points_bucket{le="10"} 1`)
+			So(out, ShouldContainSubstring, `snap_influxdb_publisher_batch_size_points_bucket{le="1000"} 2`)
+			So(out, ShouldContainSubstring, `snap_influxdb_publisher_write_duration_seconds_bucket{le="+Inf"} 2`)
+			So(out, ShouldContainSubstring, "snap_influxdb_publisher_write_duration_seconds_sum 2.02\n")
+			So(out, ShouldContainSubstring, "# TYPE snap_influxdb_publisher_connections gauge\n")
+		})
+	})
+
+	Convey("Given a Prometheus listener", t, func() {
+		logger := log.WithField("test", "prometheus")
+		addr := "127.0.0.1:0"
+		So(servePrometheus(addr, logger), ShouldBeNil)
+
+		Convey("it is started once per address", func() {
+			prometheusListenersMutex.Lock()
+			l := prometheusListeners[addr]
+			prometheusListenersMutex.Unlock()
+			So(servePrometheus(addr, logger), ShouldBeNil)
+			prometheusListenersMutex.Lock()
+			So(prometheusListeners[addr], ShouldEqual, l)
+			prometheusListenersMutex.Unlock()
+
+			Convey("it serves the statistics", func() {
+				resp, err := http.Get("http://" + l.Addr().String() + "/metrics")
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				body, err := ioutil.ReadAll(resp.Body)
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(string(body), ShouldContainSubstring, "snap_influxdb_publisher_points_written_total")
+			})
+
+			Convey("it is closed when the publisher is closed", func() {
+				So(NewInfluxPublisher().Close(), ShouldBeNil)
+				prometheusListenersMutex.Lock()
+				So(prometheusListeners, ShouldBeEmpty)
+				prometheusListenersMutex.Unlock()
+
+				_, err := net.Dial("tcp", l.Addr().String())
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a task serving the statistics to Prometheus", t, func() {
+		addr := "127.0.0.1:0"
+		config := plugin.Config{
+			"host":              "localhost",
+			"port":              int64(8086),
+			"database":          "test",
+			"retention":         "autogen",
+			"scheme":            HTTP,
+			"skip-verify":       false,
+			"isMultiFields":     false,
+			"dry-run":           true,
+			"prometheus-listen": addr,
+		}
+		metrics := []plugin.Metric{
+			{Namespace: plugin.NewNamespace("intel", "cpu", "idle"), Timestamp: time.Now(), Data: 1.5},
+		}
+		ip := NewInfluxPublisher()
+		So(ip.Publish(metrics, config), ShouldBeNil)
+		So(ip.Close(), ShouldBeNil)
+
+		Convey("the statistics are served again when it publishes after the publisher was closed", func() {
+			So(ip.Publish(metrics, config), ShouldBeNil)
+			defer ip.Close()
+			prometheusListenersMutex.Lock()
+			l := prometheusListeners[addr]
+			prometheusListenersMutex.Unlock()
+			So(l, ShouldNotBeNil)
+
+			resp, err := http.Get("http://" + l.Addr().String() + "/metrics")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		})
+	})
+}
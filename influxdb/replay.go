@@ -0,0 +1,246 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+// Maximum size of the datagrams written over UDP, the default of the client library
+const udpPayloadSize = 512
+
+// Waits between batches to limit the rate of replays
+var sleep = time.Sleep
+
+// ReplayOptions control how line protocol is replayed
+type ReplayOptions struct {
+	// Maximum number of points written at once
+	BatchSize int
+	// Maximum number of points written per second, 0 means no limit
+	Rate float64
+	// Lines starting before this byte offset are not written, to resume a replay
+	Offset int64
+	// Called after every batch written
+	Progress func(ReplayProgress)
+}
+
+// ReplayProgress tells how far a replay went
+type ReplayProgress struct {
+	// Byte offset following the last line written, where to resume the replay
+	Offset  int64
+	Points  int64
+	Batches int64
+	// Lines skipped since they are before the offset to resume from
+	Skipped int64
+	Elapsed time.Duration
+}
+
+// replayBatch is a batch of lines to write to a destination
+type replayBatch struct {
+	dest      destination
+	precision string
+	lines     bytes.Buffer
+	points    int
+	// Offset following the last line of the batch
+	end int64
+}
+
+// Replay writes line protocol, e.g. files written by debug-dump, to InfluxDB with the connection of a configuration.
+// Points are written to the database and retention policy of the configuration, unless they follow
+// a header such as "# batch db=test rp=autogen precision=s". Other comments and empty lines are ignored.
+// On failure, the progress tells the offset to resume from.
+func Replay(r io.Reader, pluginConfig plugin.Config, opts ReplayOptions) (ReplayProgress, error) {
+	progress := ReplayProgress{Offset: opts.Offset}
+	config, err := getConfig(pluginConfig)
+	if err != nil {
+		return progress, err
+	}
+	logger := getLogger(config)
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 5000
+	}
+
+	start := time.Now()
+	batch := &replayBatch{dest: destination{config.database, config.retention}, precision: config.precision}
+	flush := func() error {
+		if batch.points == 0 {
+			return nil
+		}
+		if err := replayWrite(config, batch); err != nil {
+			return fmt.Errorf("writing to %s failed, resume from offset %d: %s", batch.dest.database, progress.Offset, err)
+		}
+		progress.Offset = batch.end
+		progress.Points += int64(batch.points)
+		progress.Batches++
+		progress.Elapsed = time.Since(start)
+		batch.lines.Reset()
+		batch.points = 0
+
+		// Wait until the points written match the rate
+		if opts.Rate > 0 {
+			due := time.Duration(float64(progress.Points) / opts.Rate * float64(time.Second))
+			if wait := due - time.Since(start); wait > 0 {
+				sleep(wait)
+			}
+		}
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		return nil
+	}
+
+	reader := bufio.NewReader(r)
+	var offset int64
+	for {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return progress, readErr
+		}
+		lineStart := offset
+		offset += int64(len(line))
+		text := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(text, "# batch "):
+			dest, precision := parseBatchHeader(text, batch.dest, batch.precision)
+			if dest != batch.dest || precision != batch.precision {
+				if err := flush(); err != nil {
+					return progress, err
+				}
+				batch.dest, batch.precision = dest, precision
+			}
+		case text == "" || strings.HasPrefix(text, "#"):
+		case lineStart < opts.Offset:
+			progress.Skipped++
+		default:
+			if isTemplate(batch.dest.database) || isTemplate(batch.dest.retention) {
+				return progress, fmt.Errorf("line at offset %d has no destination, the database or retention policy of the configuration is a template", lineStart)
+			}
+			batch.lines.WriteString(text)
+			batch.lines.WriteByte('\n')
+			batch.points++
+			batch.end = offset
+			if batch.points >= opts.BatchSize {
+				if err := flush(); err != nil {
+					return progress, err
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if err := flush(); err != nil {
+		return progress, err
+	}
+	progress.Elapsed = time.Since(start)
+	logger.WithField("points", progress.Points).Debug("Replay done")
+	return progress, nil
+}
+
+// parseBatchHeader reads the destination and precision of a batch header, keeping the current ones for missing keys
+func parseBatchHeader(header string, dest destination, precision string) (destination, string) {
+	for _, kv := range strings.Fields(strings.TrimPrefix(header, "# batch ")) {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "db":
+			dest.database = parts[1]
+		case "rp":
+			dest.retention = parts[1]
+		case "precision":
+			precision = parts[1]
+		}
+	}
+	return dest, precision
+}
+
+// replayWrite writes a batch with a connection of the pool, over HTTP, or straight to the UDP endpoint
+func replayWrite(config configuration, batch *replayBatch) error {
+	config.database = batch.dest.database
+	config.retention = batch.dest.retention
+	logger := getLogger(config)
+
+	if config.scheme == UDP {
+		u, err := endpointURL(config)
+		if err != nil {
+			return err
+		}
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		for _, datagram := range udpDatagrams(batch.lines.Bytes(), udpPayloadSize) {
+			if _, err := conn.Write(datagram); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	con, err := selectClientConnection(config, logger)
+	if err != nil {
+		return err
+	}
+	err = con.writeLines(batch.dest, batch.precision, batch.lines.Bytes())
+	if err != nil {
+		pool.remove(con)
+	}
+	return err
+}
+
+// udpDatagrams splits lines into payloads of at most size bytes without splitting lines,
+// as the client library does, a line longer than size is sent on its own
+func udpDatagrams(lines []byte, size int) [][]byte {
+	datagrams := [][]byte{}
+	for len(lines) > 0 {
+		n := 0
+		for n < len(lines) {
+			end := bytes.IndexByte(lines[n:], '\n') + 1
+			if end == 0 {
+				end = len(lines) - n
+			}
+			if n > 0 && n+end > size {
+				break
+			}
+			n += end
+		}
+		datagrams = append(datagrams, lines[:n])
+		lines = lines[n:]
+	}
+	return datagrams
+}
+
+// writeLines writes line protocol to a destination
+func (c *clientConnection) writeLines(dest destination, precision string, lines []byte) error {
+	return writeLines(c.httpClient(), c.url, c.auth, dest, precision, "", lines)
+}
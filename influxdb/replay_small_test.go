@@ -0,0 +1,178 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+func TestReplay(t *testing.T) {
+	Convey("Given an InfluxDB server", t, func() {
+		type write struct {
+			db, rp, precision, body string
+		}
+		writes := []write{}
+		status := http.StatusNoContent
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			q := r.URL.Query()
+			writes = append(writes, write{q.Get("db"), q.Get("rp"), q.Get("precision"), string(body)})
+			w.WriteHeader(status)
+		}))
+		defer ts.Close()
+		u, _ := url.Parse(ts.URL)
+		host, p, _ := net.SplitHostPort(u.Host)
+		port, _ := strconv.ParseInt(p, 10, 64)
+
+		config := plugin.Config{
+			"host":            host,
+			"port":            port,
+			"database":        "test",
+			"retention":       "autogen",
+			"scheme":          HTTP,
+			"skip-verify":     false,
+			"isMultiFields":   false,
+			"create-database": CreateDatabaseNever,
+		}
+		input := "a value=1 1\n" +
+			"# batch db=other rp=week precision=s\n" +
+			"b value=2 2\n" +
+			"\n" +
+			"c value=3 3\n" +
+			"d value=4 4\n"
+
+		Convey("lines are written in batches to the destination of their header", func() {
+			progress, err := Replay(strings.NewReader(input), config, ReplayOptions{BatchSize: 2})
+			So(err, ShouldBeNil)
+			So(progress.Points, ShouldEqual, 4)
+			So(progress.Batches, ShouldEqual, 3)
+			So(progress.Offset, ShouldEqual, len(input))
+			So(writes, ShouldResemble, []write{
+				{"test", "autogen", "ns", "a value=1 1\n"},
+				{"other", "week", "s", "b value=2 2\nc value=3 3\n"},
+				{"other", "week", "s", "d value=4 4\n"},
+			})
+		})
+
+		Convey("a replay resumes from an offset", func() {
+			offset := int64(strings.Index(input, "c value"))
+			progress, err := Replay(strings.NewReader(input), config, ReplayOptions{Offset: offset})
+			So(err, ShouldBeNil)
+			So(progress.Skipped, ShouldEqual, 2)
+			So(writes, ShouldResemble, []write{
+				{"other", "week", "s", "c value=3 3\nd value=4 4\n"},
+			})
+		})
+
+		Convey("a failure tells where to resume from", func() {
+			status = http.StatusBadRequest
+			progress, err := Replay(strings.NewReader(input), config, ReplayOptions{BatchSize: 2})
+			So(err, ShouldNotBeNil)
+			So(progress.Offset, ShouldEqual, 0)
+			So(progress.Points, ShouldEqual, 0)
+		})
+
+		Convey("the rate is limited", func() {
+			waited := time.Duration(0)
+			sleep = func(d time.Duration) { waited += d }
+			defer func() { sleep = time.Sleep }()
+
+			_, err := Replay(strings.NewReader(input), config, ReplayOptions{BatchSize: 2, Rate: 1})
+			So(err, ShouldBeNil)
+			So(waited, ShouldBeGreaterThan, 3*time.Second)
+		})
+	})
+
+	Convey("Given an InfluxDB UDP endpoint", t, func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		host, p, _ := net.SplitHostPort(conn.LocalAddr().String())
+		port, _ := strconv.ParseInt(p, 10, 64)
+
+		config := plugin.Config{
+			"host":          host,
+			"port":          port,
+			"database":      "test",
+			"retention":     "autogen",
+			"scheme":        UDP,
+			"skip-verify":   false,
+			"isMultiFields": false,
+		}
+
+		Convey("batches larger than a datagram are split between lines", func() {
+			lines := []string{}
+			for i := 0; i < 2000; i++ {
+				lines = append(lines, "intel/psutil/load/load1,source=host1 value=1.5 "+strconv.Itoa(1500000000+i))
+			}
+			input := strings.Join(lines, "\n") + "\n"
+			So(len(input), ShouldBeGreaterThan, 64*1024)
+
+			received := make(chan []string)
+			go func() {
+				datagrams := []string{}
+				size := 0
+				buf := make([]byte, 64*1024)
+				for size < len(input) {
+					conn.SetReadDeadline(time.Now().Add(time.Second))
+					n, _, err := conn.ReadFrom(buf)
+					if err != nil {
+						break
+					}
+					datagrams = append(datagrams, string(buf[:n]))
+					size += n
+				}
+				received <- datagrams
+			}()
+
+			progress, err := Replay(strings.NewReader(input), config, ReplayOptions{BatchSize: 5000})
+			So(err, ShouldBeNil)
+			So(progress.Points, ShouldEqual, 2000)
+
+			// Datagrams may be dropped when the buffer of the socket is full
+			datagrams := <-received
+			So(len(datagrams), ShouldBeGreaterThan, 1)
+			So(input, ShouldStartWith, datagrams[0])
+			for _, d := range datagrams {
+				So(len(d), ShouldBeLessThanOrEqualTo, udpPayloadSize)
+				So(strings.HasSuffix(d, "\n"), ShouldBeTrue)
+			}
+		})
+	})
+
+	Convey("Lines are split into datagrams of a maximum size", t, func() {
+		So(udpDatagrams([]byte("aa\nbb\ncc\n"), 6), ShouldResemble, [][]byte{[]byte("aa\nbb\n"), []byte("cc\n")})
+		So(udpDatagrams([]byte("aaaaaaaa\nb"), 6), ShouldResemble, [][]byte{[]byte("aaaaaaaa\n"), []byte("b")})
+		So(udpDatagrams(nil, 6), ShouldBeEmpty)
+	})
+}
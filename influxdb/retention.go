@@ -0,0 +1,219 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+// Elements of InfluxQL duration literals, e.g. "7d", "1h30m" or "168h0m0s"
+var durationElement = regexp.MustCompile(`(\d+)(ns|u|µ|ms|s|m|h|d|w)`)
+
+// retentionSettings are the desired settings of a retention policy
+type retentionSettings struct {
+	name          string
+	duration      string
+	replication   int64
+	shardDuration string
+	isDefault     bool
+	alter         bool
+}
+
+// retentionPolicy are the settings of an existing retention policy
+type retentionPolicy struct {
+	duration      time.Duration
+	shardDuration time.Duration
+	replication   int64
+	isDefault     bool
+}
+
+// getRetentionSettings reads the settings of the retention policy, which is managed only when its duration is set
+func getRetentionSettings(config plugin.Config, name string) (retentionSettings, error) {
+	rs := retentionSettings{name: name, replication: 1}
+	var err error
+
+	rs.duration, err = config.GetString("retention-duration")
+	if err != nil || rs.duration == "" {
+		return retentionSettings{}, nil
+	}
+	if _, err := parseInfluxDuration(rs.duration); err != nil {
+		return rs, fmt.Errorf("invalid value for %s: %s", "retention-duration", rs.duration)
+	}
+	if isTemplate(name) {
+		return rs, fmt.Errorf("retention-duration cannot be used when retention is a template: %s", name)
+	}
+
+	if replication, err := config.GetInt("retention-replication"); err == nil {
+		rs.replication = replication
+	}
+	if rs.replication < 1 {
+		return rs, fmt.Errorf("invalid value for %s: %d", "retention-replication", rs.replication)
+	}
+
+	if rs.shardDuration, err = config.GetString("retention-shard-duration"); err == nil && rs.shardDuration != "" {
+		if _, err := parseInfluxDuration(rs.shardDuration); err != nil {
+			return rs, fmt.Errorf("invalid value for %s: %s", "retention-shard-duration", rs.shardDuration)
+		}
+	}
+
+	rs.isDefault, _ = config.GetBool("retention-default")
+	rs.alter, _ = config.GetBool("retention-alter")
+	return rs, nil
+}
+
+// parseInfluxDuration parses InfluxQL duration literals, "INF" stands for an infinite duration which InfluxDB reports as 0
+func parseInfluxDuration(s string) (time.Duration, error) {
+	if strings.ToUpper(s) == "INF" {
+		return 0, nil
+	}
+	if durationElement.ReplaceAllString(s, "") != "" || s == "" {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+
+	units := map[string]time.Duration{
+		"ns": time.Nanosecond,
+		"u":  time.Microsecond,
+		"µ":  time.Microsecond,
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"m":  time.Minute,
+		"h":  time.Hour,
+		"d":  24 * time.Hour,
+		"w":  7 * 24 * time.Hour,
+	}
+	var d time.Duration
+	for _, e := range durationElement.FindAllStringSubmatch(s, -1) {
+		n, err := strconv.ParseInt(e[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(n) * units[e[2]]
+	}
+	return d, nil
+}
+
+// drift lists the differences between the desired settings and an existing retention policy
+func (rs retentionSettings) drift(rp retentionPolicy) []string {
+	diffs := []string{}
+	if d, _ := parseInfluxDuration(rs.duration); d != rp.duration {
+		diffs = append(diffs, fmt.Sprintf("duration is %s instead of %s", rp.duration, d))
+	}
+	if rs.replication != rp.replication {
+		diffs = append(diffs, fmt.Sprintf("replication is %d instead of %d", rp.replication, rs.replication))
+	}
+	if rs.shardDuration != "" {
+		if d, _ := parseInfluxDuration(rs.shardDuration); d != rp.shardDuration {
+			diffs = append(diffs, fmt.Sprintf("shard duration is %s instead of %s", rp.shardDuration, d))
+		}
+	}
+	if rs.isDefault && !rp.isDefault {
+		diffs = append(diffs, "policy is not the default one")
+	}
+	return diffs
+}
+
+// statement returns the CREATE or ALTER statement of the retention policy on a database
+func (rs retentionSettings) statement(verb, db string) string {
+	stmt := fmt.Sprintf("%s RETENTION POLICY %s ON %s DURATION %s REPLICATION %d",
+		verb, quoteIdent(rs.name), quoteIdent(db), rs.duration, rs.replication)
+	if rs.shardDuration != "" {
+		stmt += " SHARD DURATION " + rs.shardDuration
+	}
+	if rs.isDefault {
+		stmt += " DEFAULT"
+	}
+	return stmt
+}
+
+// Get the retention policies of a database by name
+func (c *clientConnection) retentionPolicies(db string) (map[string]retentionPolicy, error) {
+	resp, err := c.query("GET", fmt.Sprintf("SHOW RETENTION POLICIES ON %s", quoteIdent(db)))
+	if err != nil {
+		return nil, err
+	}
+
+	policies := map[string]retentionPolicy{}
+	for _, r := range resp.Results {
+		for _, s := range r.Series {
+			for _, v := range s.Values {
+				row := map[string]interface{}{}
+				for i, c := range s.Columns {
+					if i < len(v) {
+						row[c] = v[i]
+					}
+				}
+				name, _ := row["name"].(string)
+				duration, _ := row["duration"].(string)
+				shardDuration, _ := row["shardGroupDuration"].(string)
+				replication, _ := row["replicaN"].(float64)
+				isDefault, _ := row["default"].(bool)
+
+				rp := retentionPolicy{replication: int64(replication), isDefault: isDefault}
+				rp.duration, _ = parseInfluxDuration(duration)
+				rp.shardDuration, _ = parseInfluxDuration(shardDuration)
+				policies[name] = rp
+			}
+		}
+	}
+	return policies, nil
+}
+
+// Create the retention policy if it doesn't exist, report its drift from the desired settings
+// and alter it when allowed to
+func (c *clientConnection) ensureRetentionPolicy(db string, rs retentionSettings, logger *log.Entry) error {
+	policies, err := c.retentionPolicies(db)
+	if err != nil {
+		return err
+	}
+
+	rp, ok := policies[rs.name]
+	if !ok {
+		logger.WithFields(log.Fields{
+			"database":  db,
+			"retention": rs.name,
+		}).Info("Creating retention policy")
+		_, err = c.query("POST", rs.statement("CREATE", db))
+		return err
+	}
+
+	diffs := rs.drift(rp)
+	if len(diffs) == 0 {
+		return nil
+	}
+	logger.WithFields(log.Fields{
+		"database":  db,
+		"retention": rs.name,
+		"drift":     strings.Join(diffs, ", "),
+		"altering":  rs.alter,
+	}).Warn("Retention policy differs from its configuration")
+	if !rs.alter {
+		return nil
+	}
+	_, err = c.query("POST", rs.statement("ALTER", db))
+	return err
+}
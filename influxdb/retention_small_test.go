@@ -0,0 +1,108 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	Convey("InfluxQL durations are parsed", t, func() {
+		for s, d := range map[string]time.Duration{
+			"7d":       7 * 24 * time.Hour,
+			"1h30m":    90 * time.Minute,
+			"168h0m0s": 168 * time.Hour,
+			"2w":       14 * 24 * time.Hour,
+			"INF":      0,
+		} {
+			parsed, err := parseInfluxDuration(s)
+			So(err, ShouldBeNil)
+			So(parsed, ShouldEqual, d)
+		}
+		_, err := parseInfluxDuration("7 days")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Retention policy settings are only read when a duration is set", t, func() {
+		rs, err := getRetentionSettings(plugin.Config{}, "autogen")
+		So(err, ShouldBeNil)
+		So(rs.duration, ShouldEqual, "")
+
+		rs, err = getRetentionSettings(plugin.Config{"retention-duration": "7d", "retention-default": true}, "week")
+		So(err, ShouldBeNil)
+		So(rs.statement("CREATE", "test"), ShouldEqual, `CREATE RETENTION POLICY "week" ON "test" DURATION 7d REPLICATION 1 DEFAULT`)
+
+		_, err = getRetentionSettings(plugin.Config{"retention-duration": "a week"}, "week")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given an InfluxDB server with a retention policy", t, func() {
+		queries := []string{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query().Get("q")
+			queries = append(queries, q)
+			if q == `SHOW RETENTION POLICIES ON "test"` {
+				w.Write([]byte(`{"results":[{"statement_id":0,"series":[{"columns":["name","duration","shardGroupDuration","replicaN","default"],"values":[["autogen","0s","168h0m0s",1,true],["week","168h0m0s","24h0m0s",1,false]]}]}]}`))
+				return
+			}
+			w.Write([]byte(`{"results":[{"statement_id":0}]}`))
+		}))
+		defer ts.Close()
+		u, _ := url.Parse(ts.URL)
+		c := &clientConnection{url: u}
+		logger := log.WithField("test", "retention")
+
+		Convey("a missing policy is created", func() {
+			rs := retentionSettings{name: "month", duration: "30d", replication: 1}
+			So(c.ensureRetentionPolicy("test", rs, logger), ShouldBeNil)
+			So(queries, ShouldHaveLength, 2)
+			So(queries[1], ShouldEqual, `CREATE RETENTION POLICY "month" ON "test" DURATION 30d REPLICATION 1`)
+		})
+
+		Convey("a matching policy is left untouched", func() {
+			rs := retentionSettings{name: "week", duration: "7d", replication: 1, shardDuration: "1d"}
+			So(c.ensureRetentionPolicy("test", rs, logger), ShouldBeNil)
+			So(queries, ShouldHaveLength, 1)
+		})
+
+		Convey("a drifting policy is only reported", func() {
+			rs := retentionSettings{name: "week", duration: "14d", replication: 1}
+			So(c.ensureRetentionPolicy("test", rs, logger), ShouldBeNil)
+			So(queries, ShouldHaveLength, 1)
+		})
+
+		Convey("a drifting policy is altered when allowed to", func() {
+			rs := retentionSettings{name: "week", duration: "14d", replication: 1, alter: true}
+			So(c.ensureRetentionPolicy("test", rs, logger), ShouldBeNil)
+			So(queries, ShouldHaveLength, 2)
+			So(queries[1], ShouldEqual, `ALTER RETENTION POLICY "week" ON "test" DURATION 14d REPLICATION 1`)
+		})
+	})
+}
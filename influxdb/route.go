@@ -0,0 +1,109 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// route sends the metrics matching a namespace pattern or a tag value to another database and retention policy
+type route struct {
+	pattern   string
+	tag       string
+	value     string
+	database  string
+	retention string
+}
+
+// parseRoutes parses routing rules separated by ";", each in the form
+// "<namespace pattern> => <database>[:<retention>]" or "<tag>=<value pattern> => <database>[:<retention>]"
+func parseRoutes(rules string) ([]route, error) {
+	routes := []route{}
+	for _, rule := range strings.Split(rules, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		parts := strings.SplitN(rule, "=>", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid route, expected '<match> => <database>[:<retention>]': %s", rule)
+		}
+		match := strings.TrimSpace(parts[0])
+		target := strings.SplitN(strings.TrimSpace(parts[1]), ":", 2)
+
+		r := route{database: strings.TrimSpace(target[0])}
+		if len(target) == 2 {
+			r.retention = strings.TrimSpace(target[1])
+		}
+		if r.database == "" {
+			return nil, fmt.Errorf("invalid route, missing database: %s", rule)
+		}
+
+		if strings.HasPrefix(match, "/") {
+			r.pattern = match
+		} else {
+			kv := strings.SplitN(match, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, fmt.Errorf("invalid route, expected a namespace pattern or '<tag>=<value>': %s", rule)
+			}
+			r.tag, r.value = strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		}
+		if _, err := path.Match(r.pattern+r.value, ""); err != nil {
+			return nil, fmt.Errorf("invalid route, bad pattern: %s", rule)
+		}
+
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// matches checks if a metric namespace or its tags match the route
+func (r route) matches(ns []string, tags map[string]string) bool {
+	if r.pattern != "" {
+		ok, _ := path.Match(r.pattern, "/"+strings.Join(ns, "/"))
+		return ok
+	}
+	v, found := tags[r.tag]
+	if !found {
+		return false
+	}
+	ok, _ := path.Match(r.value, v)
+	return ok
+}
+
+// routeMetric returns the destination of the first route matching a metric,
+// or the configured database and retention policy when none match.
+// Templates of the destination are expanded with the tags of the metric.
+func routeMetric(ns []string, tags map[string]string, config configuration) (destination, error) {
+	dest := destination{database: config.database, retention: config.retention}
+	for _, r := range config.routes {
+		if r.matches(ns, tags) {
+			dest.database = r.database
+			if r.retention != "" {
+				dest.retention = r.retention
+			}
+			break
+		}
+	}
+	return expandDestination(dest, tags, config.databaseAllow)
+}
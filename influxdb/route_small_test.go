@@ -0,0 +1,80 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+func TestRoutes(t *testing.T) {
+	Convey("Routes are parsed from rules", t, func() {
+		routes, err := parseRoutes("/intel/psutil/cpu/* => metrics_hf:1d; tier=business => business:1y ;/intel/* => other")
+		So(err, ShouldBeNil)
+		So(routes, ShouldResemble, []route{
+			{pattern: "/intel/psutil/cpu/*", database: "metrics_hf", retention: "1d"},
+			{tag: "tier", value: "business", database: "business", retention: "1y"},
+			{pattern: "/intel/*", database: "other"},
+		})
+
+		Convey("invalid rules are rejected", func() {
+			for _, rule := range []string{"/intel/*", "/intel/* => ", "business => db", "/intel/[ => db"} {
+				_, err := parseRoutes(rule)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("metrics are routed by the first matching rule", func() {
+			config := configuration{database: "default", retention: "autogen", routes: routes}
+			dest, _ := routeMetric([]string{"intel", "psutil", "cpu", "idle"}, nil, config)
+			So(dest, ShouldResemble, destination{"metrics_hf", "1d"})
+			dest, _ = routeMetric([]string{"acme", "orders"}, map[string]string{"tier": "business"}, config)
+			So(dest, ShouldResemble, destination{"business", "1y"})
+			dest, _ = routeMetric([]string{"intel", "mem"}, nil, config)
+			So(dest, ShouldResemble, destination{"other", "autogen"})
+			dest, _ = routeMetric([]string{"acme", "orders"}, nil, config)
+			So(dest, ShouldResemble, destination{"default", "autogen"})
+		})
+	})
+
+	Convey("Converted points carry their destination", t, func() {
+		routes, _ := parseRoutes("/intel/cpu/* => hf:short")
+		config := configuration{database: "default", retention: "autogen", isMultiFields: true, routes: routes}
+		now := time.Now()
+		points := convertMetrics([]plugin.Metric{
+			{Namespace: plugin.NewNamespace("intel", "cpu", "idle"), Timestamp: now, Data: 1},
+			{Namespace: plugin.NewNamespace("intel", "mem", "free"), Timestamp: now, Data: 2},
+		}, config, log.WithField("test", "route"))
+		So(points, ShouldHaveLength, 2)
+		for _, p := range points {
+			if p.ns[1] == "cpu" {
+				So(p.dest, ShouldResemble, destination{"hf", "short"})
+			} else {
+				So(p.dest, ShouldResemble, destination{"default", "autogen"})
+			}
+		}
+	})
+}
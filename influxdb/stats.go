@@ -0,0 +1,317 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/influxdata/influxdb/client/v2"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+const (
+	// Measurement of the statistics written to stats-database
+	statsMeasurement = "snap_influxdb_publisher"
+
+	// Reasons why points are dropped
+	dropNil         = "nil"
+	dropRoute       = "route"
+	dropDedup       = "dedup"
+	dropCardinality = "cardinality"
+)
+
+var (
+	// Statistics of the publisher, shared by all tasks
+	stats = newPublisherStats()
+	// Writers of the statistics, by destination and interval
+	statsWriters = make(map[string]*statsWriter)
+	// Mutex for synchronizing statistics writers changes
+	statsWritersMutex = &sync.Mutex{}
+	// Clock of the statistics writers, tests replace it along with the function writing the statistics
+	statsClock clock = realClock{}
+	statsWrite       = writeStats
+
+	// Upper bounds of the buckets of batch sizes, in points
+	batchSizeBuckets = []float64{1, 10, 100, 1000, 10000}
+	// Upper bounds of the buckets of write latencies, in seconds
+	writeLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// histogram counts observations in buckets, bucket i holds observations lower than or equal
+// to bounds[i] and greater than the previous bound, the last bucket holds the others
+type histogram struct {
+	bounds []float64
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// addFields adds the cumulative count of observations lower than or equal to each bound
+// as the <name>_le_<bound> fields, like the buckets of a Prometheus histogram
+func (h histogram) addFields(fields map[string]interface{}, name string) {
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fields[name+"_le_"+formatFloat(bound)] = cumulative
+	}
+}
+
+func (h histogram) copy() histogram {
+	c := h
+	c.counts = append([]int64(nil), h.counts...)
+	return c
+}
+
+// publisherStats counts what the publisher does since it started
+type publisherStats struct {
+	written      int64
+	failed       int64
+	dropped      map[string]int64
+	overflows    int64
+	batches      int64
+	writeErrors  int64
+	retries      int64
+	batchSize    histogram
+	writeLatency histogram
+	mutex        sync.Mutex
+}
+
+// statsSnapshot is a copy of the statistics at some point in time
+type statsSnapshot struct {
+	written, failed, overflows, batches, writeErrors, retries int64
+	dropped                                                   map[string]int64
+	batchSize, writeLatency                                   histogram
+	connections                                               int
+	maxIdle                                                   time.Duration
+	pending                                                   int
+}
+
+func newPublisherStats() *publisherStats {
+	return &publisherStats{
+		dropped:      map[string]int64{dropNil: 0, dropRoute: 0, dropDedup: 0, dropCardinality: 0},
+		batchSize:    newHistogram(batchSizeBuckets),
+		writeLatency: newHistogram(writeLatencyBuckets),
+	}
+}
+
+// drop counts points dropped for a reason
+func (s *publisherStats) drop(reason string, n int) {
+	if n <= 0 {
+		return
+	}
+	s.mutex.Lock()
+	s.dropped[reason] += int64(n)
+	s.mutex.Unlock()
+}
+
+// overflow counts uint64 values which overflowed when converted to int64
+func (s *publisherStats) overflow() {
+	s.mutex.Lock()
+	s.overflows++
+	s.mutex.Unlock()
+}
+
+// retry counts batches written again after a failure
+func (s *publisherStats) retry() {
+	s.mutex.Lock()
+	s.retries++
+	s.mutex.Unlock()
+}
+
+// batch counts a batch of points written, or which failed to be written when err is not nil
+func (s *publisherStats) batch(points int, latency time.Duration, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.batches++
+	s.batchSize.observe(float64(points))
+	s.writeLatency.observe(latency.Seconds())
+	if err != nil {
+		s.writeErrors++
+		s.failed += int64(points)
+		return
+	}
+	s.written += int64(points)
+}
+
+func (s *publisherStats) snapshot() statsSnapshot {
+	s.mutex.Lock()
+	snap := statsSnapshot{
+		written:      s.written,
+		failed:       s.failed,
+		overflows:    s.overflows,
+		batches:      s.batches,
+		writeErrors:  s.writeErrors,
+		retries:      s.retries,
+		dropped:      make(map[string]int64, len(s.dropped)),
+		batchSize:    s.batchSize.copy(),
+		writeLatency: s.writeLatency.copy(),
+	}
+	for reason, n := range s.dropped {
+		snap.dropped[reason] = n
+	}
+	s.mutex.Unlock()
+
+	snap.connections, snap.maxIdle = pool.state()
+
+	snap.pending = pendingAggregates()
+	return snap
+}
+
+// fields returns the statistics as the fields of a point, counters are cumulative since the publisher started
+func (s statsSnapshot) fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"points_written": s.written,
+		"points_failed":  s.failed,
+		"overflows":      s.overflows,
+		"batches":        s.batches,
+		"batch_points":   s.batchSize.sum,
+		"write_errors":   s.writeErrors,
+		"write_retries":  s.retries,
+		"write_seconds":  s.writeLatency.sum,
+		"connections":    s.connections,
+		"pending_points": s.pending,
+	}
+	for reason, n := range s.dropped {
+		fields["points_dropped_"+reason] = n
+	}
+	s.batchSize.addFields(fields, "batch_points")
+	s.writeLatency.addFields(fields, "write_seconds")
+	return fields
+}
+
+// statsWriter writes the statistics of the publisher to a stats database every interval, until stopped
+type statsWriter struct {
+	config       configuration
+	pluginConfig plugin.Config
+	secretFiles  bool
+	logger       *log.Entry
+	done         chan struct{}
+	stopped      chan struct{}
+}
+
+// startStatsWriter starts writing the statistics to the stats-database of a configuration every stats-interval.
+// There is one writer per destination and interval, started by the first configuration using them.
+func startStatsWriter(config configuration, pluginConfig plugin.Config, logger *log.Entry) {
+	config = statsConfig(config)
+	key := fmt.Sprintf("%s/%s", destinationKey(config), config.statsInterval)
+
+	statsWritersMutex.Lock()
+	defer statsWritersMutex.Unlock()
+
+	if statsWriters[key] != nil {
+		return
+	}
+	w := &statsWriter{
+		config:       config,
+		pluginConfig: pluginConfig,
+		secretFiles:  hasSecretFiles(pluginConfig),
+		logger:       logger,
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+	statsWriters[key] = w
+	go w.run(statsClock)
+}
+
+// run writes the statistics every interval, independently of publishing so that they keep
+// being written when the pipeline stalls
+func (w *statsWriter) run(clk clock) {
+	defer close(w.stopped)
+	for {
+		select {
+		case <-clk.After(w.config.statsInterval):
+		case <-w.done:
+			return
+		}
+
+		config := w.config
+		if w.secretFiles {
+			var err error
+			if config, err = refreshCredentials(w.config, w.pluginConfig); err != nil {
+				w.logger.WithField("err", err).Warn("Unable to refresh the credentials of the statistics database")
+				continue
+			}
+		}
+		statsWrite(config, w.logger)
+	}
+}
+
+// stopStatsWriters stops the writers of the statistics and waits for writes in progress
+func stopStatsWriters() {
+	statsWritersMutex.Lock()
+	writers := statsWriters
+	statsWriters = make(map[string]*statsWriter)
+	statsWritersMutex.Unlock()
+
+	for _, w := range writers {
+		close(w.done)
+		<-w.stopped
+	}
+}
+
+// statsConfig returns the configuration writing to the stats-database of a configuration
+func statsConfig(config configuration) configuration {
+	config.database = config.statsDatabase
+	config.retention = ""
+	// The retention policy and continuous queries are only managed on databases of metrics
+	config.retentionPolicy = retentionSettings{}
+	config.continuousQueries = ""
+	config.provisioning = nil
+	return config
+}
+
+// writeStats writes the statistics of the publisher with a configuration from statsConfig,
+// failures are logged
+func writeStats(config configuration, logger *log.Entry) {
+	host, _ := os.Hostname()
+	pt, err := client.NewPoint(statsMeasurement, map[string]string{"source": host}, stats.snapshot().fields(), time.Now())
+	if err != nil {
+		logger.WithField("err", err).Error("Unable to create the statistics point")
+		return
+	}
+	bps, err := client.NewBatchPoints(client.BatchPointsConfig{Database: config.database, Precision: config.precision})
+	if err != nil {
+		logger.WithField("err", err).Error("Unable to create the statistics batch")
+		return
+	}
+	bps.AddPoint(pt)
+
+	if err := writeBatch(config, destination{database: config.database}, bps, logger); err != nil {
+		logger.WithField("err", err).Warn("Unable to write the statistics of the publisher")
+	}
+}
@@ -0,0 +1,140 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+func TestStats(t *testing.T) {
+	Convey("Given a histogram", t, func() {
+		h := newHistogram([]float64{1, 10})
+		for _, v := range []float64{0.5, 1, 5, 20} {
+			h.observe(v)
+		}
+		So(h.counts, ShouldResemble, []int64{2, 1, 1})
+		So(h.count, ShouldEqual, 4)
+		So(h.sum, ShouldEqual, 26.5)
+	})
+
+	Convey("Given publisher statistics", t, func() {
+		s := newPublisherStats()
+
+		Convey("batches are counted as written or failed", func() {
+			s.batch(10, 20*time.Millisecond, nil)
+			s.batch(5, time.Second, errors.New("timeout"))
+			s.retry()
+			snap := s.snapshot()
+			So(snap.written, ShouldEqual, 10)
+			So(snap.failed, ShouldEqual, 5)
+			So(snap.batches, ShouldEqual, 2)
+			So(snap.writeErrors, ShouldEqual, 1)
+			So(snap.retries, ShouldEqual, 1)
+			So(snap.batchSize.sum, ShouldEqual, 15)
+			So(snap.writeLatency.count, ShouldEqual, 2)
+		})
+
+		Convey("dropped points are counted by reason", func() {
+			s.drop(dropNil, 2)
+			s.drop(dropDedup, 0)
+			fields := s.snapshot().fields()
+			So(fields["points_dropped_nil"], ShouldEqual, 2)
+			So(fields["points_dropped_dedup"], ShouldEqual, 0)
+		})
+
+		Convey("histogram buckets are written as cumulative counts", func() {
+			s.batch(5, 50*time.Millisecond, nil)
+			s.batch(50, 2*time.Second, nil)
+			fields := s.snapshot().fields()
+			So(fields["batch_points_le_1"], ShouldEqual, 0)
+			So(fields["batch_points_le_10"], ShouldEqual, 1)
+			So(fields["batch_points_le_100"], ShouldEqual, 2)
+			So(fields["write_seconds_le_0.05"], ShouldEqual, 1)
+			So(fields["write_seconds_le_1"], ShouldEqual, 1)
+			So(fields["write_seconds_le_2.5"], ShouldEqual, 2)
+		})
+
+		Convey("snapshots are not changed by later updates", func() {
+			s.batch(1, time.Millisecond, nil)
+			snap := s.snapshot()
+			s.batch(1, time.Millisecond, nil)
+			So(snap.written, ShouldEqual, 1)
+			So(snap.batchSize.counts[0], ShouldEqual, 1)
+		})
+	})
+
+	Convey("Metrics with nil values are counted as dropped", t, func() {
+		before := stats.snapshot().dropped[dropNil]
+		convertMetrics([]plugin.Metric{
+			{Namespace: plugin.NewNamespace("intel", "cpu", "idle"), Timestamp: time.Now(), Data: nil},
+		}, configuration{}, log.WithField("test", "stats"))
+		So(stats.snapshot().dropped[dropNil], ShouldEqual, before+1)
+	})
+
+	Convey("Given a configuration writing the statistics", t, func() {
+		clk := &fakeClock{now: time.Now()}
+		statsClock = clk
+		written := make(chan configuration, 10)
+		statsWrite = func(config configuration, logger *log.Entry) {
+			written <- config
+		}
+		defer func() {
+			stopStatsWriters()
+			statsClock = realClock{}
+			statsWrite = writeStats
+		}()
+		config := configuration{database: "metrics", statsDatabase: "monitoring", statsInterval: time.Minute, precision: "s"}
+		logger := log.WithField("test", "stats")
+
+		Convey("they are written every interval, without publishing", func() {
+			startStatsWriter(config, plugin.Config{}, logger)
+			startStatsWriter(config, plugin.Config{}, logger)
+			for i := 0; i < 2; i++ {
+				clk.blockUntil(1)
+				clk.advance(time.Minute)
+				c := <-written
+				So(c.database, ShouldEqual, "monitoring")
+			}
+			So(written, ShouldBeEmpty)
+
+			Convey("until the writers are stopped", func() {
+				stopStatsWriters()
+				clk.advance(time.Minute)
+				So(written, ShouldBeEmpty)
+				So(statsWriters, ShouldBeEmpty)
+			})
+		})
+
+		Convey("other intervals have writers of their own", func() {
+			startStatsWriter(config, plugin.Config{}, logger)
+			config.statsInterval = time.Hour
+			startStatsWriter(config, plugin.Config{}, logger)
+			So(statsWriters, ShouldHaveLength, 2)
+		})
+	})
+}
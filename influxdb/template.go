@@ -0,0 +1,99 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Placeholders of tags in database and retention policy templates, e.g. "metrics_{tenant}"
+	templatePlaceholder = regexp.MustCompile(`\{([^{}]+)\}`)
+	// Tag values allowed in templates, anything else could be used to inject InfluxQL
+	templateValue = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+)
+
+// isTemplate checks if a database or retention policy name contains tag placeholders
+func isTemplate(s string) bool {
+	return templatePlaceholder.MatchString(s)
+}
+
+// expandTemplate replaces the tag placeholders of a template with the values of the tags
+func expandTemplate(tmpl string, tags map[string]string) (string, error) {
+	var err error
+	expanded := templatePlaceholder.ReplaceAllStringFunc(tmpl, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		value, ok := tags[name]
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("missing tag %s to expand %s", name, tmpl)
+			}
+			return placeholder
+		}
+		if !templateValue.MatchString(value) {
+			if err == nil {
+				err = fmt.Errorf("invalid value of tag %s to expand %s: %q", name, tmpl, value)
+			}
+			return placeholder
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// expandDestination expands the templates of a destination, the resulting
+// database must match one of the allowed patterns when it was templated
+func expandDestination(dest destination, tags map[string]string, allowed []string) (destination, error) {
+	if !isTemplate(dest.database) && !isTemplate(dest.retention) {
+		return dest, nil
+	}
+
+	database, err := expandTemplate(dest.database, tags)
+	if err != nil {
+		return dest, err
+	}
+	retention, err := expandTemplate(dest.retention, tags)
+	if err != nil {
+		return dest, err
+	}
+
+	if isTemplate(dest.database) && !isAllowed(database, allowed) {
+		return dest, fmt.Errorf("database %s is not allowed by database-allow", database)
+	}
+	return destination{database: database, retention: retention}, nil
+}
+
+// isAllowed checks if a name matches one of the patterns
+func isAllowed(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteIdent quotes an InfluxQL identifier such as a database or retention policy name
+func quoteIdent(name string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+}
@@ -0,0 +1,87 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+func TestTemplates(t *testing.T) {
+	tags := map[string]string{"tenant": "acme", "rp": "short", "evil": `x"; DROP DATABASE y`}
+	allowed := []string{"metrics_*"}
+
+	Convey("Templates are expanded with tags", t, func() {
+		dest, err := expandDestination(destination{"metrics_{tenant}", "{rp}"}, tags, allowed)
+		So(err, ShouldBeNil)
+		So(dest, ShouldResemble, destination{"metrics_acme", "short"})
+	})
+
+	Convey("Destinations without templates are not checked", t, func() {
+		dest, err := expandDestination(destination{"static", "autogen"}, tags, nil)
+		So(err, ShouldBeNil)
+		So(dest, ShouldResemble, destination{"static", "autogen"})
+	})
+
+	Convey("Expansion fails", t, func() {
+		Convey("when a tag is missing", func() {
+			_, err := expandDestination(destination{"metrics_{customer}", "autogen"}, tags, allowed)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("when a tag value is not a plain name", func() {
+			_, err := expandDestination(destination{"metrics_{evil}", "autogen"}, tags, []string{"*"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("when the database is not allowed", func() {
+			_, err := expandDestination(destination{"{tenant}", "autogen"}, tags, allowed)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("A templated database requires an allow-list", t, func() {
+		config := plugin.Config{
+			"host":          "localhost",
+			"port":          int64(8086),
+			"database":      "metrics_{tenant}",
+			"user":          "root",
+			"password":      "root",
+			"retention":     "autogen",
+			"scheme":        HTTP,
+			"skip-verify":   false,
+			"isMultiFields": false,
+		}
+		_, err := getConfig(config)
+		So(err, ShouldNotBeNil)
+
+		config["database-allow"] = "metrics_*"
+		_, err = getConfig(config)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Identifiers are quoted", t, func() {
+		So(quoteIdent(`my "db"`), ShouldEqual, `"my \"db\""`)
+	})
+}
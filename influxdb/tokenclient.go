@@ -0,0 +1,119 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+// tokenClient is a client.Client writing over HTTP with token authentication,
+// which is not supported by the client library
+type tokenClient struct {
+	url   *url.URL
+	token string
+	http  *http.Client
+}
+
+func newTokenClient(u *url.URL, token string, skipVerify bool) client.Client {
+	return &tokenClient{
+		url:   u,
+		token: token,
+		http:  newHTTPClient(skipVerify),
+	}
+}
+
+// Ping checks that InfluxDB is reachable
+func (c *tokenClient) Ping(timeout time.Duration) (time.Duration, string, error) {
+	start := time.Now()
+	req, err := http.NewRequest("GET", c.url.String()+"/ping", nil)
+	if err != nil {
+		return 0, "", err
+	}
+	// The client used for writes is shared, pings have one of their own with its own timeout
+	ping := &http.Client{Transport: c.http.Transport, Timeout: timeout}
+	resp, err := ping.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return 0, "", fmt.Errorf("ping failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return time.Since(start), resp.Header.Get("X-Influxdb-Version"), nil
+}
+
+// Write writes the batch points in line protocol
+func (c *tokenClient) Write(bps client.BatchPoints) error {
+	dest := destination{database: bps.Database(), retention: bps.RetentionPolicy()}
+	return writeLines(c.http, c.url, credentials{token: c.token}, dest, bps.Precision(), bps.WriteConsistency(), lineProtocol(bps))
+}
+
+// writeLines posts line protocol to the write endpoint of InfluxDB
+func writeLines(h *http.Client, u *url.URL, auth credentials, dest destination, precision, consistency string, lines []byte) error {
+	req, err := http.NewRequest("POST", u.String()+"/write", bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	auth.authorize(req)
+
+	params := req.URL.Query()
+	params.Set("db", dest.database)
+	params.Set("rp", dest.retention)
+	params.Set("precision", precision)
+	if consistency != "" {
+		params.Set("consistency", consistency)
+	}
+	req.URL.RawQuery = params.Encode()
+
+	resp, err := h.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("write failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Query is not supported, queries are run by the connection
+func (c *tokenClient) Query(q client.Query) (*client.Response, error) {
+	return nil, errors.New("queries are not supported by the token client")
+}
+
+// Close closes idle connections
+func (c *tokenClient) Close() error {
+	if tr, ok := c.http.Transport.(*http.Transport); ok {
+		tr.CloseIdleConnections()
+	}
+	return nil
+}
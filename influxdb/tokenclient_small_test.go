@@ -0,0 +1,92 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/client/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenClient(t *testing.T) {
+	Convey("Given an InfluxDB server", t, func() {
+		var request *http.Request
+		var body string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/ping" {
+				w.Header().Set("X-Influxdb-Version", "1.3.0")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			data, _ := ioutil.ReadAll(r.Body)
+			request, body = r, string(data)
+			if r.Header.Get("Authorization") != "Token abc" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"authorization failed"}`))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+		u, _ := url.Parse(ts.URL)
+
+		bps, _ := client.NewBatchPoints(client.BatchPointsConfig{Database: "test", RetentionPolicy: "autogen", Precision: "s"})
+		pt, _ := client.NewPoint("load", map[string]string{"source": "host1"}, map[string]interface{}{"value": 1.5}, time.Unix(1500000000, 0))
+		bps.AddPoint(pt)
+
+		Convey("points are written with the token", func() {
+			err := newTokenClient(u, "abc", false).Write(bps)
+			So(err, ShouldBeNil)
+			So(request.URL.Path, ShouldEqual, "/write")
+			So(request.URL.Query().Get("db"), ShouldEqual, "test")
+			So(request.URL.Query().Get("rp"), ShouldEqual, "autogen")
+			So(body, ShouldEqual, pt.PrecisionString("s")+"\n")
+		})
+
+		Convey("write errors are reported", func() {
+			err := newTokenClient(u, "wrong", false).Write(bps)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "authorization failed")
+		})
+
+		Convey("pings don't change the timeout of writes", func() {
+			c := newTokenClient(u, "abc", false)
+			done := make(chan error)
+			go func() {
+				_, _, err := c.Ping(time.Second)
+				done <- err
+			}()
+			So(c.Write(bps), ShouldBeNil)
+			So(<-done, ShouldBeNil)
+
+			_, version, err := c.Ping(time.Second)
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, "1.3.0")
+			So(c.(*tokenClient).http.Timeout, ShouldEqual, httpTimeout)
+		})
+	})
+}
@@ -0,0 +1,185 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package influxdb
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// precisions are the timestamp precisions accepted by the InfluxDB client
+var precisions = []string{"ns", "us", "ms", "s", "m", "h"}
+
+// precisionAliases are the short names of precisions documented before, which
+// are still accepted
+var precisionAliases = map[string]string{"n": "ns", "u": "us"}
+
+// identifier matches retention policy names, which may be templates
+var identifier = regexp.MustCompile(`^[A-Za-z0-9_.\-{}]+$`)
+
+// hostname matches host names and IPv4 addresses
+var hostname = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9\-_.]*[A-Za-z0-9])?$`)
+
+// configErrors collects the problems of a configuration so that all of them are reported at once
+type configErrors []string
+
+// add records a problem
+func (e *configErrors) add(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+// check records err when it is not nil
+func (e *configErrors) check(err error) {
+	if err != nil {
+		*e = append(*e, err.Error())
+	}
+}
+
+// err returns the collected problems as an error, nil when there is none
+func (e configErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e configErrors) Error() string {
+	return fmt.Sprintf("invalid configuration (%d problems): %s", len(e), strings.Join(e, "; "))
+}
+
+// validate checks the consistency of a configuration whose items have been read,
+// and loads the continuous queries file so that it is checked along with them
+func validate(cfg *configuration, errs *configErrors) {
+	switch cfg.scheme {
+	case HTTP, HTTPS, UDP:
+	default:
+		errs.add("scheme %q is not supported, use %s, %s or %s", cfg.scheme, HTTP, HTTPS, UDP)
+	}
+
+	if cfg.port < 1 || cfg.port > 65535 {
+		errs.add("port %d is out of range 1-65535", cfg.port)
+	}
+
+	if !validPrecision(cfg.precision) {
+		errs.add("precision %q is not supported, use one of %s", cfg.precision, strings.Join(precisions, ", "))
+	}
+
+	errs.check(validateHost(cfg.host))
+
+	if cfg.retention != "" && !identifier.MatchString(cfg.retention) {
+		errs.add("retention %q is not a valid retention policy name, use letters, digits, _, . and -", cfg.retention)
+	}
+	for _, r := range cfg.routes {
+		if r.retention != "" && !identifier.MatchString(r.retention) {
+			errs.add("retention %q of a route is not a valid retention policy name, use letters, digits, _, . and -", r.retention)
+		}
+		// Only the retention policy named by retention is managed, on every database written to
+		if cfg.retentionPolicy.duration != "" && r.retention != "" && r.retention != cfg.retention {
+			errs.add("retention-duration cannot be used with routes to other retention policies than %q: %s", cfg.retention, r.retention)
+		}
+	}
+
+	if isTemplate(cfg.statsDatabase) {
+		errs.add("stats-database %q cannot be a template", cfg.statsDatabase)
+	}
+	if cfg.statsInterval < 0 {
+		errs.add("stats-interval %s must be positive", cfg.statsInterval)
+	}
+	if cfg.connectionIdleTimeout < 0 {
+		errs.add("connection-idle-timeout %s must be positive", cfg.connectionIdleTimeout)
+	}
+
+	if cfg.prometheusListen != "" {
+		if _, _, err := net.SplitHostPort(cfg.prometheusListen); err != nil {
+			errs.add("prometheus-listen %q is not an address such as :9273", cfg.prometheusListen)
+		}
+	}
+
+	if cfg.debugDump != "" {
+		if cfg.debugDumpMaxSize <= 0 {
+			errs.add("debug-dump-max-size %d must be at least 1 MB", cfg.debugDumpMaxSize/1024/1024)
+		}
+		if cfg.debugDumpBackups < 0 {
+			errs.add("debug-dump-backups %d must not be negative", cfg.debugDumpBackups)
+		}
+		if cfg.debugDumpSampling <= 0 || cfg.debugDumpSampling > 1 {
+			errs.add("debug-dump-sampling %g must be greater than 0 and at most 1", cfg.debugDumpSampling)
+		}
+	}
+
+	// Settings requiring queries cannot be used over UDP
+	if cfg.scheme == UDP {
+		if cfg.createDatabase == CreateDatabaseVerifyOnly {
+			errs.add("create-database %s cannot be used with scheme %s", CreateDatabaseVerifyOnly, UDP)
+		}
+		if cfg.retentionPolicy.duration != "" {
+			errs.add("retention-duration cannot be used with scheme %s", UDP)
+		}
+		if cfg.continuousQueries != "" {
+			errs.add("continuous-queries cannot be used with scheme %s", UDP)
+		}
+	} else if cfg.continuousQueries != "" {
+		p, err := loadProvisioning(cfg.continuousQueries)
+		errs.check(err)
+		if err == nil {
+			cfg.provisioning = &p
+		}
+	}
+}
+
+func validPrecision(precision string) bool {
+	for _, p := range precisions {
+		if precision == p {
+			return true
+		}
+	}
+	return false
+}
+
+// validateHost checks that host is a host name or an IP address, without scheme, port or path
+func validateHost(host string) error {
+	switch {
+	case host == "":
+		return nil
+	case strings.Contains(host, "://"):
+		return fmt.Errorf("host %q must not contain the scheme, use scheme", host)
+	case strings.ContainsAny(host, "/?#@ "):
+		return fmt.Errorf("host %q must be a host name or an IP address", host)
+	}
+	// IPv6 addresses are enclosed in brackets, e.g. [::1]
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		if ip := net.ParseIP(host[1 : len(host)-1]); ip != nil && ip.To4() == nil {
+			return nil
+		}
+		return fmt.Errorf("host %q is not a valid IPv6 address", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return fmt.Errorf("host %q is an IPv6 address, it must be enclosed in brackets", host)
+	}
+	if strings.Contains(host, ":") {
+		return fmt.Errorf("host %q must not contain the port, use port", host)
+	}
+	if !hostname.MatchString(host) {
+		return fmt.Errorf("host %q must be a host name or an IP address", host)
+	}
+	return nil
+}
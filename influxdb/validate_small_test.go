@@ -0,0 +1,115 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package influxdb
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
+)
+
+func TestValidate(t *testing.T) {
+	Convey("Given a valid configuration", t, func() {
+		config := plugin.Config{
+			"host":          "localhost",
+			"port":          int64(8086),
+			"database":      "test",
+			"retention":     "autogen",
+			"scheme":        HTTP,
+			"precision":     "s",
+			"skip-verify":   false,
+			"isMultiFields": false,
+		}
+
+		Convey("it is accepted", func() {
+			cfg, err := getConfig(config)
+			So(err, ShouldBeNil)
+			So(cfg.precision, ShouldEqual, "s")
+		})
+
+		Convey("precision defaults to nanoseconds", func() {
+			delete(config, "precision")
+			cfg, err := getConfig(config)
+			So(err, ShouldBeNil)
+			So(cfg.precision, ShouldEqual, "ns")
+		})
+
+		Convey("short precisions are accepted", func() {
+			for alias, precision := range map[string]string{"n": "ns", "u": "us"} {
+				config["precision"] = alias
+				cfg, err := getConfig(config)
+				So(err, ShouldBeNil)
+				So(cfg.precision, ShouldEqual, precision)
+			}
+		})
+
+		Convey("all problems are reported at once", func() {
+			delete(config, "database")
+			config["scheme"] = "htp"
+			config["port"] = int64(70000)
+			config["precision"] = "x"
+			config["retention"] = "one year"
+			config["dedup"] = "all"
+			_, err := getConfig(config)
+			So(err, ShouldNotBeNil)
+			So(err, ShouldHaveSameTypeAs, configErrors{})
+			So(err.(configErrors), ShouldHaveLength, 6)
+			So(err.Error(), ShouldContainSubstring, "database is required")
+			So(err.Error(), ShouldContainSubstring, `scheme "htp" is not supported`)
+			So(err.Error(), ShouldContainSubstring, "port 70000 is out of range")
+			So(err.Error(), ShouldContainSubstring, `precision "x" is not supported`)
+			So(err.Error(), ShouldContainSubstring, `retention "one year"`)
+			So(err.Error(), ShouldContainSubstring, "invalid value for dedup: all")
+		})
+
+		Convey("settings requiring queries are rejected over UDP", func() {
+			config["scheme"] = UDP
+			config["create-database"] = CreateDatabaseVerifyOnly
+			config["retention-duration"] = "7d"
+			_, err := getConfig(config)
+			So(err, ShouldNotBeNil)
+			So(err.(configErrors), ShouldHaveLength, 2)
+		})
+
+		Convey("managed retention policies can only be routed to by name", func() {
+			config["retention-duration"] = "7d"
+			config["routes"] = "/intel/cpu/* => cpu; /intel/mem/* => mem:autogen"
+			_, err := getConfig(config)
+			So(err, ShouldBeNil)
+
+			config["routes"] = "/intel/cpu/* => cpu:1d"
+			_, err = getConfig(config)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "routes to other retention policies")
+		})
+	})
+
+	Convey("Host must be a host name or an IP address", t, func() {
+		for _, host := range []string{"localhost", "influxdb.example.com", "10.0.0.1", "[::1]", "influx_db"} {
+			So(validateHost(host), ShouldBeNil)
+		}
+		for _, host := range []string{"http://localhost", "localhost:8086", "::1", "[localhost]", "host/path", "-host"} {
+			So(validateHost(host), ShouldNotBeNil)
+		}
+	})
+}
@@ -20,10 +20,32 @@ limitations under the License.
 package main
 
 import (
+	"io"
+	"os"
+
 	"github.com/intelsdi-x/snap-plugin-lib-go/v1/plugin"
 	"github.com/intelsdi-x/snap-plugin-publisher-influxdb/influxdb"
 )
 
+// command runs a subcommand of the plugin binary and returns its exit code
+type command func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+
+// Subcommands, the plugin is started when the first argument is none of them
+var commands = map[string]command{
+	"convert": convert,
+	"check":   check,
+	"replay":  replay,
+}
+
 func main() {
-	plugin.StartPublisher(influxdb.NewInfluxPublisher(), influxdb.Name, influxdb.Version)
+	ip := influxdb.NewInfluxPublisher()
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			code := cmd(os.Args[2:], os.Stdin, os.Stdout, os.Stderr)
+			ip.Close()
+			os.Exit(code)
+		}
+	}
+	plugin.StartPublisher(ip, influxdb.Name, influxdb.Version)
+	ip.Close()
 }
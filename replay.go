@@ -0,0 +1,99 @@
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2016 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/intelsdi-x/snap-plugin-publisher-influxdb/influxdb"
+)
+
+// How often the progress of a replay is printed
+var progressInterval = 10 * time.Second
+
+// replay writes line protocol read from a file, e.g. written by debug-dump, to InfluxDB
+func replay(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "configuration of the publisher, flat YAML or JSON")
+	batchSize := flags.Int("batch-size", 5000, "maximum number of points written at once")
+	rate := flags.Float64("rate", 0, "maximum number of points written per second, 0 means no limit")
+	offset := flags.Int64("offset", 0, "byte offset of the file to resume a replay from")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: snap-plugin-publisher-influxdb replay -config <file> [-batch-size <points>] [-rate <points/s>] [-offset <bytes>] [<file>|-]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *configPath == "" || flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	config, err := readConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "replay:", err)
+		return 1
+	}
+
+	in := stdin
+	if path := flags.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(stderr, "replay:", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	printed := time.Now()
+	progress, err := influxdb.Replay(in, config, influxdb.ReplayOptions{
+		BatchSize: *batchSize,
+		Rate:      *rate,
+		Offset:    *offset,
+		Progress: func(p influxdb.ReplayProgress) {
+			if time.Since(printed) >= progressInterval {
+				printProgress(stderr, p)
+				printed = time.Now()
+			}
+		},
+	})
+	printProgress(stdout, progress)
+	if err != nil {
+		fmt.Fprintln(stderr, "replay:", err)
+		return 1
+	}
+	return 0
+}
+
+func printProgress(w io.Writer, p influxdb.ReplayProgress) {
+	rate := 0.0
+	if p.Elapsed > 0 {
+		rate = float64(p.Points) / p.Elapsed.Seconds()
+	}
+	fmt.Fprintf(w, "replayed %d points in %d batches in %s (%.0f points/s), skipped %d lines, offset %d\n",
+		p.Points, p.Batches, p.Elapsed, rate, p.Skipped, p.Offset)
+}
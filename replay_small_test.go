@@ -0,0 +1,75 @@
+// +build small
+
+/*
+http://www.apache.org/licenses/LICENSE-2.0.txt
+
+
+Copyright 2015 Intel Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReplay(t *testing.T) {
+	Convey("Given an InfluxDB server and a dump", t, func() {
+		bodies := []string{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+		u, _ := url.Parse(ts.URL)
+
+		dir, err := ioutil.TempDir("", "influxdb")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		config := filepath.Join(dir, "config.yml")
+		host := strings.Split(u.Host, ":")
+		So(ioutil.WriteFile(config, []byte("host: "+host[0]+"\nport: "+host[1]+"\ndatabase: test\ncreate-database: false\n"), 0644), ShouldBeNil)
+		dump := filepath.Join(dir, "dump.lp")
+		So(ioutil.WriteFile(dump, []byte("# batch db=test rp=autogen precision=s\na value=1 1\nb value=2 2\n"), 0644), ShouldBeNil)
+
+		Convey("the points of the dump are written", func() {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			So(replay([]string{"-config", config, "-batch-size", "1", dump}, strings.NewReader(""), stdout, stderr), ShouldEqual, 0)
+			So(stderr.String(), ShouldBeEmpty)
+			So(bodies, ShouldResemble, []string{"a value=1 1\n", "b value=2 2\n"})
+			So(stdout.String(), ShouldStartWith, "replayed 2 points in 2 batches")
+		})
+
+		Convey("the replay resumes from an offset", func() {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			offset := strings.Index("# batch db=test rp=autogen precision=s\na value=1 1\nb value=2 2\n", "b value")
+			So(replay([]string{"-config", config, "-offset", strconv.Itoa(offset), dump}, strings.NewReader(""), stdout, stderr), ShouldEqual, 0)
+			So(bodies, ShouldResemble, []string{"b value=2 2\n"})
+			So(stdout.String(), ShouldContainSubstring, "skipped 1 lines")
+		})
+	})
+}